@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/urfave/cli/v2"
+)
+
+// withCache opens the configured cache storage for the duration of the action
+func withCache(action func(c *cli.Context, cacheImpl cache.Cache) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		conf, err := loadConfig(c)
+		if err != nil {
+			return err
+		}
+		if conf.CacheSettings.Storage.IsMemory() {
+			return fmt.Errorf("memory cache is available from the running proxy only, use the admin API")
+		}
+		logger.InitLogger(conf.LogLevel, conf.LogPrettyPrint)
+
+		ctx, done := context.WithCancel(context.Background())
+		defer done()
+		cacheImpl, err := cache.FromConfig(ctx, conf)
+		if err != nil {
+			return err
+		}
+		err = action(c, cacheImpl)
+		if closeErr := cacheImpl.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+}
+
+func cacheListCommand(c *cli.Context, cacheImpl cache.Cache) error {
+	entries, err := cacheImpl.Entries()
+	if err != nil {
+		return err
+	}
+	method := c.String("method")
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tMETHOD\tSIZE\tSTALE")
+	offset, limit := c.Int("offset"), c.Int("limit")
+	for _, entry := range entries {
+		if method != "" && entry.Request.Method != method {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if limit == 0 {
+			break
+		}
+		limit--
+		fmt.Fprintf(w, "%s\t%s\t%d\t%t\n", entry.Key, entry.Request.Method, entry.Size, entry.IsStale(now))
+	}
+	return w.Flush()
+}
+
+func cacheGetCommand(c *cli.Context, cacheImpl cache.Cache) error {
+	key := c.Args().First()
+	if key == "" {
+		return fmt.Errorf("cache key is required")
+	}
+	entry, ok, err := cacheImpl.GetEntry(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("cache key %q is not found", key)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entry)
+}
+
+func cachePurgeCommand(c *cli.Context, cacheImpl cache.Cache) error {
+	key, method, all := c.String("key"), c.String("method"), c.Bool("all")
+	switch {
+	case key != "":
+		_, ok, err := cacheImpl.GetEntry(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("cache key %q is not found", key)
+		}
+		if err := cacheImpl.Delete(key); err != nil {
+			return err
+		}
+		fmt.Printf("Purged cache key %s\n", key)
+	case method != "":
+		purged, err := cache.PurgeMatching(cacheImpl, func(m string) bool {
+			return m == method
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Purged %d cache keys of method %s\n", purged, method)
+	case all:
+		if err := cacheImpl.Clean(); err != nil {
+			return err
+		}
+		fmt.Println("Purged all cache keys")
+	default:
+		return fmt.Errorf("one of --key, --method or --all is required")
+	}
+	return nil
+}
+
+func cacheStatsCommand(_ *cli.Context, cacheImpl cache.Cache) error {
+	entries, err := cacheImpl.Entries()
+	if err != nil {
+		return err
+	}
+	stats := cache.NewStats(entries, time.Now())
+	methods := make([]string, 0, len(stats.Methods))
+	for method := range stats.Methods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tENTRIES\tBYTES\tSTALE")
+	for _, method := range methods {
+		s := stats.Methods[method]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", method, s.Entries, s.Bytes, s.Stale)
+	}
+	fmt.Fprintf(w, "TOTAL\t%d\t%d\t\n", stats.Entries, stats.Bytes)
+	return w.Flush()
+}
+
+func cacheCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "cache",
+		Usage: "Inspect and purge the configured cache storage",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "ls",
+				Usage:  "List cached keys",
+				Action: withCache(cacheListCommand),
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "method",
+						Usage: "List keys of the method only",
+					},
+					&cli.IntFlag{
+						Name:  "offset",
+						Usage: "Skip the first keys",
+					},
+					&cli.IntFlag{
+						Name:  "limit",
+						Value: 100,
+						Usage: "Maximum number of keys to list",
+					},
+				},
+			},
+			{
+				Name:      "get",
+				Usage:     "Show the cached request and response",
+				ArgsUsage: "KEY",
+				Action:    withCache(cacheGetCommand),
+			},
+			{
+				Name:   "purge",
+				Usage:  "Delete cached keys",
+				Action: withCache(cachePurgeCommand),
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "key",
+						Usage: "Delete the key",
+					},
+					&cli.StringFlag{
+						Name:  "method",
+						Usage: "Delete all keys of the method",
+					},
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "Delete all keys",
+					},
+				},
+			},
+			{
+				Name:   "stats",
+				Usage:  "Show number and size of cached keys per method",
+				Action: withCache(cacheStatsCommand),
+			},
+		},
+	}
+}
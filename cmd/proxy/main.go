@@ -11,11 +11,13 @@ import (
 	"time"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/chain"
 	"github.com/protofire/filecoin-rpc-proxy/internal/config"
 	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
 	"github.com/protofire/filecoin-rpc-proxy/internal/matcher"
 	"github.com/protofire/filecoin-rpc-proxy/internal/metrics"
 	"github.com/protofire/filecoin-rpc-proxy/internal/proxy"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
 	"github.com/protofire/filecoin-rpc-proxy/internal/updater"
 	"github.com/protofire/filecoin-rpc-proxy/internal/utils"
 	"github.com/sirupsen/logrus"
@@ -43,19 +45,23 @@ func getConfigExample() (string, error) {
 	return string(c), nil
 }
 
-func startCommand(c *cli.Context) error {
+func loadConfig(c *cli.Context) (*config.Config, error) {
 	configFile := c.String("config")
 	if configFile == "" {
 		configFile = getDefaultConfigFilePath()
 	}
 	if !utils.FileExists(configFile) {
-		return fmt.Errorf("cannot find conf file file: %s", configFile)
+		return nil, fmt.Errorf("cannot find conf file file: %s", configFile)
 	}
-	conf, err := config.FromFile(configFile, config.CmdLineParams{
+	return config.FromFile(configFile, config.CmdLineParams{
 		JWTSecret: c.String("jwt-secret"),
 		ProxyURL:  c.String("proxy-url"),
 		RedisURI:  c.String("redis-uri"),
 	})
+}
+
+func startCommand(c *cli.Context) error {
+	conf, err := loadConfig(c)
 	if err != nil {
 		return err
 	}
@@ -80,10 +86,17 @@ func startCommand(c *cli.Context) error {
 		return err
 	}
 
+	tracker, err := chain.FromConfig(conf, log)
+	if err != nil {
+		done()
+		return err
+	}
+
 	cacher := proxy.NewResponseCache(
 		cacheImpl,
-		matcher.FromConfig(conf),
+		matcher.FromConfigWithHead(conf, tracker),
 	)
+	tracker.OnNewHead(proxy.NewHeadInvalidator(cacher, log))
 	transportImp := proxy.NewTransport(cacher, log, conf.DebugHTTPRequest, conf.DebugHTTPResponse)
 
 	updaterImp, err := updater.FromConfig(conf, cacher, log)
@@ -97,6 +110,17 @@ func startCommand(c *cli.Context) error {
 		done()
 		return err
 	}
+	server.SetHeadProvider(tracker)
+	updaterImp.SetHeadProvider(tracker)
+
+	var warmup requests.RPCRequests
+	if conf.CacheSettings.WarmupFile != "" {
+		if warmup, err = updater.ReadWarmupFile(conf.CacheSettings.WarmupFile); err != nil {
+			done()
+			return err
+		}
+		server.SetReady(false)
+	}
 
 	defer func() {
 		done()
@@ -108,15 +132,22 @@ func startCommand(c *cli.Context) error {
 	handler := proxy.PrepareRoutes(conf, log, server)
 	s := server.StartHTTPServer(handler)
 
+	go tracker.Start(ctx, conf.Chain.HeadPollPeriod)
+	if warmup != nil {
+		go func() {
+			if err := updaterImp.Warmup(warmup); err != nil {
+				log.Errorf("Cannot warm up cache: %v", err)
+			}
+			log.Info("Cache warmup has been finished")
+			server.SetReady(true)
+		}()
+	}
 	go updaterImp.StartMethodUpdater(ctx, conf.UpdateCustomCachePeriod)
 	go updaterImp.StartCacheUpdater(ctx, conf.UpdateUserCachePeriod)
 
 	sig := <-stop
 	log.Infof("Caught sig: %+v. Waiting process is being stopped...", sig)
 	done()
-	if err := cacheImpl.Close(); err != nil {
-		log.Error(err)
-	}
 
 	ctxUpdater, cancelUpdater := context.WithTimeout(context.Background(), time.Duration(conf.ShutdownTimeout)*time.Second)
 	defer cancelUpdater()
@@ -155,6 +186,7 @@ func prepareCliApp() *cli.App {
 	app.Usage = "JSON PRC cached proxy"
 	app.EnableBashCompletion = true
 	app.Action = startCommand
+	app.Commands = []*cli.Command{cacheCommand()}
 	app.Description = fmt.Sprintf(`
 Default config file is: ~/config.yaml
 Config file example:
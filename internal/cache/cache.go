@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/protofire/filecoin-rpc-proxy/internal/codec"
 	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/config"
@@ -23,15 +24,187 @@ func (e Error) Error() string {
 }
 
 type cacheValue struct {
-	Request  requests.RPCRequest
-	Response requests.RPCResponse
+	Request  requests.RPCRequest  `json:"request"`
+	Response requests.RPCResponse `json:"response"`
+	// unix nanoseconds. zero means no expiration
+	FreshUntil int64 `json:"fresh_until,omitempty"`
+	KeepUntil  int64 `json:"keep_until,omitempty"`
+}
+
+func newCacheValue(request requests.RPCRequest, response requests.RPCResponse, ttl, maxStale time.Duration) cacheValue {
+	value := cacheValue{
+		Request:  request,
+		Response: response,
+	}
+	if ttl > 0 {
+		now := time.Now()
+		value.FreshUntil = now.Add(ttl).UnixNano()
+		value.KeepUntil = now.Add(ttl + maxStale).UnixNano()
+	}
+	return value
+}
+
+// isFresh reports whether the value can be served
+func (v cacheValue) isFresh(now time.Time) bool {
+	return v.FreshUntil == 0 || now.UnixNano() <= v.FreshUntil
+}
+
+// isExpired reports whether the value should be dropped from the storage
+func (v cacheValue) isExpired(now time.Time) bool {
+	return v.KeepUntil != 0 && now.UnixNano() > v.KeepUntil
+}
+
+// indexValue is the cached request stored apart from the response,
+// so cached requests are scanned without reading responses
+type indexValue struct {
+	Request requests.RPCRequest `json:"request"`
+	// unix nanoseconds. zero means no expiration
+	FreshUntil int64 `json:"fresh_until,omitempty"`
+	KeepUntil  int64 `json:"keep_until,omitempty"`
+}
+
+func (v cacheValue) index() indexValue {
+	return indexValue{Request: v.Request, FreshUntil: v.FreshUntil, KeepUntil: v.KeepUntil}
+}
+
+// cacheValue returns the cache value without the response
+func (v indexValue) cacheValue() cacheValue {
+	return cacheValue{Request: v.Request, FreshUntil: v.FreshUntil, KeepUntil: v.KeepUntil}
+}
+
+func (v indexValue) isExpired(now time.Time) bool {
+	return v.KeepUntil != 0 && now.UnixNano() > v.KeepUntil
+}
+
+// narrowNumbers makes decoded numbers the same as numbers of parsed requests and responses
+func (v *cacheValue) narrowNumbers() {
+	narrowRequestNumbers(&v.Request)
+	v.Response.ID = codec.NarrowNumbers(v.Response.ID)
+	v.Response.Result = codec.NarrowNumbers(v.Response.Result)
+	if v.Response.Error != nil {
+		v.Response.Error.Data = codec.NarrowNumbers(v.Response.Error.Data)
+	}
+}
+
+func narrowRequestNumbers(request *requests.RPCRequest) {
+	request.ID = codec.NarrowNumbers(request.ID)
+	request.Params = codec.NarrowNumbers(request.Params)
+}
+
+// Entry describes the cached value
+type Entry struct {
+	Key      string               `json:"key"`
+	Request  requests.RPCRequest  `json:"request"`
+	Response requests.RPCResponse `json:"response"`
+	// encoded value size in bytes
+	Size int `json:"size"`
+	// zero means no expiration
+	FreshUntil time.Time `json:"fresh_until"`
+	KeepUntil  time.Time `json:"keep_until"`
+}
+
+func newEntry(key string, value cacheValue, size int) Entry {
+	entry := Entry{
+		Key:      key,
+		Request:  value.Request,
+		Response: value.Response,
+		Size:     size,
+	}
+	if value.FreshUntil != 0 {
+		entry.FreshUntil = time.Unix(0, value.FreshUntil).UTC()
+	}
+	// index entries written before fresh_until was indexed have keep_until only
+	if value.KeepUntil != 0 {
+		entry.KeepUntil = time.Unix(0, value.KeepUntil).UTC()
+	}
+	return entry
+}
+
+// IsStale reports whether the entry is past its ttl
+func (e Entry) IsStale(now time.Time) bool {
+	return !e.FreshUntil.IsZero() && now.After(e.FreshUntil)
+}
+
+// memoryValue is cache value kept by memory storages. The value is kept compressed if required,
+// but its request and expiration are always available
+type memoryValue struct {
+	cacheValue
+	data []byte
+}
+
+func newMemoryValue(valueCodec codec.Codec, c *Compressor, value cacheValue) (memoryValue, error) {
+	// values are encoded only if they are going to be compressed. size is estimated, so it is not encoded twice
+	if c == nil || !c.shouldCompress(value.Request.Method, estimateSize(value.Response.Result, c.minSize)) {
+		return memoryValue{cacheValue: value}, nil
+	}
+	data, err := encodeValue(valueCodec, c, value)
+	if err != nil {
+		return memoryValue{}, err
+	}
+	if !isCompressed(data) {
+		return memoryValue{cacheValue: value}, nil
+	}
+	value.Response = requests.RPCResponse{}
+	return memoryValue{cacheValue: value, data: data}, nil
+}
+
+func (v memoryValue) response() (requests.RPCResponse, error) {
+	if v.data == nil {
+		return v.Response, nil
+	}
+	value := cacheValue{}
+	if err := decodeValue(v.data, &value); err != nil {
+		return requests.RPCResponse{}, err
+	}
+	return value.Response, nil
+}
+
+func (v memoryValue) entry(key string) (Entry, bool, error) {
+	size, err := valueSize(v)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	value := v.cacheValue
+	if value.Response, err = v.response(); err != nil {
+		return Entry{}, false, err
+	}
+	return newEntry(key, value, int(size)), true, nil
+}
+
+// retention returns how long the storage keeps a value. Stale values are kept for maxStale
+// after ttl, so the cache updater is still able to refresh them
+func retention(ttl, maxStale time.Duration) time.Duration {
+	if ttl <= 0 {
+		return 0
+	}
+	return ttl + maxStale
+}
+
+// Requests returns all cached requests
+func Requests(c Cache) ([]requests.RPCRequest, error) {
+	var res []requests.RPCRequest
+	err := c.ScanRequests(func(_ string, request requests.RPCRequest) error {
+		res = append(res, request)
+		return nil
+	})
+	return res, err
 }
 
 // Cache ...
 type Cache interface {
-	Set(key string, request requests.RPCRequest, response requests.RPCResponse) error
+	Set(key string, request requests.RPCRequest, response requests.RPCResponse, ttl, maxStale time.Duration) error
 	Get(key string) (requests.RPCResponse, error)
-	Requests() ([]requests.RPCRequest, error)
+	// GetStale returns the response kept within max_stale as well. stale reports whether it is past its ttl
+	GetStale(key string) (response requests.RPCResponse, stale bool, err error)
+	Delete(key string) error
+	// ScanRequests calls fn for every cached request without reading responses. Scanning stops on fn error.
+	// fn must not modify the cache
+	ScanRequests(fn func(key string, request requests.RPCRequest) error) error
+	// Entries returns all cached entries without their responses. Persistent storages read the request index,
+	// so responses are not read
+	Entries() ([]Entry, error)
+	// GetEntry returns the cached entry with its response. false means there is no entry
+	GetEntry(key string) (Entry, bool, error)
 	Close() error
 	Clean() error
 }
@@ -39,22 +212,37 @@ type Cache interface {
 // MemoryCache ...
 type MemoryCache struct {
 	*cache.Cache
+	compressor *Compressor
+	codec      codec.Codec
+}
+
+// SetCompressor enables compression of cached values
+func (m *MemoryCache) SetCompressor(c *Compressor) {
+	m.compressor = c
 }
 
-func (m *MemoryCache) Requests() ([]requests.RPCRequest, error) {
-	res := make([]requests.RPCRequest, m.Cache.ItemCount())
-	for _, item := range m.Cache.Items() {
-		res = append(res, item.Object.(cacheValue).Request)
+// SetCodec sets the codec of cached values
+func (m *MemoryCache) SetCodec(c codec.Codec) {
+	m.codec = c
+}
+
+// ScanRequests ...
+func (m *MemoryCache) ScanRequests(fn func(key string, request requests.RPCRequest) error) error {
+	for key, item := range m.Cache.Items() {
+		if err := fn(key, item.Object.(memoryValue).Request); err != nil {
+			return err
+		}
 	}
-	return res, nil
+	return nil
 }
 
 // Set ...
-func (m *MemoryCache) Set(key string, request requests.RPCRequest, response requests.RPCResponse) error {
-	m.Cache.Set(key, cacheValue{
-		Request:  request,
-		Response: response,
-	}, 0)
+func (m *MemoryCache) Set(key string, request requests.RPCRequest, response requests.RPCResponse, ttl, maxStale time.Duration) error {
+	value, err := newMemoryValue(m.codec, m.compressor, newCacheValue(request, response, ttl, maxStale))
+	if err != nil {
+		return err
+	}
+	m.Cache.Set(key, value, retention(ttl, maxStale))
 	metrics.SetCacheSize(int64(m.Cache.ItemCount()))
 	return nil
 }
@@ -62,12 +250,78 @@ func (m *MemoryCache) Set(key string, request requests.RPCRequest, response requ
 // Get ...
 func (m *MemoryCache) Get(key string) (requests.RPCResponse, error) {
 	val, ok := m.Cache.Get(key)
-	if ok {
-		return val.(cacheValue).Response, nil
+	if ok && val.(memoryValue).isFresh(time.Now()) {
+		return val.(memoryValue).response()
 	}
 	return requests.RPCResponse{}, nil
 }
 
+// GetStale ...
+func (m *MemoryCache) GetStale(key string) (requests.RPCResponse, bool, error) {
+	val, ok := m.Cache.Get(key)
+	if !ok {
+		return requests.RPCResponse{}, false, nil
+	}
+	response, err := val.(memoryValue).response()
+	return response, !val.(memoryValue).isFresh(time.Now()), err
+}
+
+// Delete ...
+func (m *MemoryCache) Delete(key string) error {
+	m.Cache.Delete(key)
+	metrics.SetCacheSize(int64(m.Cache.ItemCount()))
+	return nil
+}
+
+// Entries ...
+func (m *MemoryCache) Entries() ([]Entry, error) {
+	items := m.Cache.Items()
+	res := make([]Entry, 0, len(items))
+	for key, item := range items {
+		value := item.Object.(memoryValue)
+		size, err := valueSize(value)
+		if err != nil {
+			return nil, err
+		}
+		value.Response = requests.RPCResponse{}
+		res = append(res, newEntry(key, value.cacheValue, int(size)))
+	}
+	return res, nil
+}
+
+// GetEntry ...
+func (m *MemoryCache) GetEntry(key string) (Entry, bool, error) {
+	val, ok := m.Cache.Get(key)
+	if !ok {
+		return Entry{}, false, nil
+	}
+	return val.(memoryValue).entry(key)
+}
+
+func (m *MemoryCache) entries() []snapshotEntry {
+	if m.Cache == nil {
+		return nil
+	}
+	items := m.Cache.Items()
+	res := make([]snapshotEntry, 0, len(items))
+	for key, item := range items {
+		res = append(res, snapshotEntry{key: key, value: item.Object.(memoryValue), expires: item.Expiration})
+	}
+	return res
+}
+
+func (m *MemoryCache) load(entries []snapshotEntry) {
+	now := time.Now()
+	for _, entry := range entries {
+		expiration := cache.NoExpiration
+		if entry.expires != 0 {
+			expiration = time.Unix(0, entry.expires).Sub(now)
+		}
+		m.Cache.Set(entry.key, entry.value, expiration)
+	}
+	metrics.SetCacheSize(int64(m.Cache.ItemCount()))
+}
+
 // Close ...
 func (m *MemoryCache) Close() error {
 	m.Cache = nil
@@ -76,14 +330,15 @@ func (m *MemoryCache) Close() error {
 
 // Clean ...
 func (m *MemoryCache) Clean() error {
-	m.Cache = nil
+	m.Cache.Flush()
+	metrics.SetCacheSize(0)
 	return nil
 }
 
 // NewMemoryCache initializes memory cache
 func NewMemoryCache(defaultExpiration, cleanupInterval time.Duration) *MemoryCache {
 	return &MemoryCache{
-		cache.New(defaultExpiration, cleanupInterval),
+		Cache: cache.New(defaultExpiration, cleanupInterval),
 	}
 }
 
@@ -98,7 +353,7 @@ func NewMemoryCacheDefault() *MemoryCache {
 // NewMemoryCacheFromConfig initializes memory cache from config
 func NewMemoryCacheFromConfig(config config.MemoryCacheSettings) *MemoryCache {
 	return &MemoryCache{
-		cache.New(
+		Cache: cache.New(
 			time.Duration(config.DefaultExpiration)*time.Second,
 			time.Duration(config.CleanupInterval)*time.Second,
 		),
@@ -107,15 +362,53 @@ func NewMemoryCacheFromConfig(config config.MemoryCacheSettings) *MemoryCache {
 
 // FromConfig initializes cache from config
 func FromConfig(ctx context.Context, c *config.Config) (Cache, error) {
+	compressor := NewCompressorFromConfig(c)
+	valueCodec, err := NewCodecFromConfig(c)
+	if err != nil {
+		return nil, err
+	}
 	switch c.CacheSettings.Storage {
 	case config.MemoryCacheStorage:
-		return NewMemoryCacheFromConfig(c.CacheSettings.Memory), nil
+		var storage memoryStorage
+		if c.CacheSettings.Memory.IsBounded() {
+			lru := NewLRUCacheFromConfig(ctx, c.CacheSettings.Memory)
+			lru.SetCompressor(compressor)
+			lru.SetCodec(valueCodec)
+			storage = lru
+		} else {
+			memory := NewMemoryCacheFromConfig(c.CacheSettings.Memory)
+			memory.SetCompressor(compressor)
+			memory.SetCodec(valueCodec)
+			storage = memory
+		}
+		if c.CacheSettings.Memory.Snapshot.IsEnabled() {
+			return NewSnapshotCacheFromConfig(ctx, storage, c.CacheSettings.Memory.Snapshot), nil
+		}
+		return storage, nil
 	case config.RedisCacheStorage:
 		client, err := NewRedisClient(ctx, c.CacheSettings.Redis)
 		if err != nil {
 			return nil, err
 		}
+		client.SetCompressor(compressor)
+		client.SetCodec(valueCodec)
 		return client, nil
+	case config.DiskCacheStorage:
+		disk, err := NewDiskCacheFromConfig(ctx, c.CacheSettings.Disk)
+		if err != nil {
+			return nil, err
+		}
+		disk.SetCompressor(compressor)
+		disk.SetCodec(valueCodec)
+		return disk, nil
+	case config.TieredCacheStorage:
+		tiered, err := NewTieredCacheFromConfig(ctx, c.CacheSettings)
+		if err != nil {
+			return nil, err
+		}
+		tiered.SetCompressor(compressor)
+		tiered.SetCodec(valueCodec)
+		return tiered, nil
 	default:
 		return nil, fmt.Errorf("unknown cache storage type: %s", c.CacheSettings.Storage)
 	}
@@ -1,14 +1,22 @@
 package cache
 
 import (
+	"os"
 	"testing"
 	"time"
 
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
 	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
 
 	"github.com/stretchr/testify/require"
 )
 
+// redis tests are skipped if redis is not available, see testhelpers.SkipWithoutRedis
+func TestMain(m *testing.M) { // nolint
+	logger.InitDefaultLogger()
+	os.Exit(m.Run())
+}
+
 func TestNewMemoryCacheDefault(t *testing.T) {
 	cache := NewMemoryCacheDefault()
 	expectedRequest := requests.RPCRequest{
@@ -23,7 +31,7 @@ func TestNewMemoryCacheDefault(t *testing.T) {
 		Result:  nil,
 		Error:   nil,
 	}
-	err := cache.Set("1", expectedRequest, expectedResponse)
+	err := cache.Set("1", expectedRequest, expectedResponse, 0, 0)
 	require.NoError(t, err)
 	value, err := cache.Get("1")
 	require.NoError(t, err)
@@ -45,10 +53,40 @@ func TestNewMemoryCacheExpired(t *testing.T) {
 		Result:  nil,
 		Error:   nil,
 	}
-	err := cache.Set("1", expectedRequest, expectedResponse)
+	err := cache.Set("1", expectedRequest, expectedResponse, 0, 0)
 	require.NoError(t, err)
 	time.Sleep(d)
 	value, err := cache.Get("1")
 	require.NoError(t, err)
 	require.True(t, value.IsEmpty())
 }
+
+func TestMemoryCacheMethodTTL(t *testing.T) {
+	ttl := time.Duration(1) * time.Second
+	cache := NewMemoryCacheDefault()
+	expectedRequest := requests.RPCRequest{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  "",
+		Params:  nil,
+	}
+	expectedResponse := requests.RPCResponse{
+		JSONRPC: "2.0",
+		ID:      1,
+		Result:  nil,
+		Error:   nil,
+	}
+	err := cache.Set("1", expectedRequest, expectedResponse, ttl, ttl)
+	require.NoError(t, err)
+	value, err := cache.Get("1")
+	require.NoError(t, err)
+	require.Equal(t, expectedResponse, value)
+	time.Sleep(ttl + ttl/2)
+	value, err = cache.Get("1")
+	require.NoError(t, err)
+	require.True(t, value.IsEmpty())
+	// stale value is kept for the cache updater
+	reqs, err := Requests(cache)
+	require.NoError(t, err)
+	require.Contains(t, reqs, expectedRequest)
+}
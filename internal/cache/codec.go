@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/codec"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+)
+
+// valueMagic prefixes encoded values. It is followed by the codec and the compression of the payload.
+// The header layout is documented in README, since other tools read values by skipping it.
+// Read as BSON document length it exceeds the maximum redis value size, so it cannot be confused with legacy values
+var valueMagic = []byte{0xfc, 0xfc, 0xfc, 0x7e}
+
+// legacyValueMagic prefixes BSON values compressed before codecs were introduced. It is followed by the compression.
+// Legacy plain values are BSON documents without any prefix
+var legacyValueMagic = []byte{0xfc, 0xfc, 0xfc, 0x7f}
+
+var defaultCodec codec.Codec = codec.JSON{}
+
+// NewCodecFromConfig returns the codec of new cache values
+func NewCodecFromConfig(c *config.Config) (codec.Codec, error) {
+	if c.CacheSettings.Codec == "" {
+		return defaultCodec, nil
+	}
+	return codec.ByName(string(c.CacheSettings.Codec))
+}
+
+// encode encodes the value with the codec and compresses it if required. nil codec means the default codec
+func encode(valueCodec codec.Codec, c *Compressor, method string, value interface{}) ([]byte, error) {
+	if valueCodec == nil {
+		valueCodec = defaultCodec
+	}
+	data, err := valueCodec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	header := append(append([]byte(nil), valueMagic...), valueCodec.ID(), compressionNone)
+	if !c.shouldCompress(method, len(data)) {
+		return append(header, data...), nil
+	}
+	header[len(header)-1] = compressionGzip
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)/4))
+	buf.Write(header)
+	if err := c.compress(method, buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decode decodes values of every codec and compression, including legacy ones
+func decode(data []byte, value interface{}) error {
+	valueCodec, compression, payload, err := parseValue(data)
+	if err != nil {
+		return err
+	}
+	if payload, err = decompress(compression, payload); err != nil {
+		return err
+	}
+	return valueCodec.Unmarshal(payload, value)
+}
+
+// parseValue splits the encoded value into its codec, compression and payload
+func parseValue(data []byte) (codec.Codec, byte, []byte, error) {
+	switch {
+	case bytes.HasPrefix(data, valueMagic):
+		if len(data) < len(valueMagic)+2 {
+			return nil, 0, nil, fmt.Errorf("truncated cache value")
+		}
+		valueCodec, err := codec.ByID(data[len(valueMagic)])
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		return valueCodec, data[len(valueMagic)+1], data[len(valueMagic)+2:], nil
+	case bytes.HasPrefix(data, legacyValueMagic):
+		if len(data) < len(legacyValueMagic)+1 {
+			return nil, 0, nil, fmt.Errorf("truncated cache value")
+		}
+		return codec.BSON{}, data[len(legacyValueMagic)], data[len(legacyValueMagic)+1:], nil
+	default:
+		return codec.BSON{}, compressionNone, data, nil
+	}
+}
+
+func isCompressed(data []byte) bool {
+	_, compression, _, err := parseValue(data)
+	return err == nil && compression != compressionNone
+}
+
+// encodeValue encodes cache value and compresses it if required
+func encodeValue(valueCodec codec.Codec, c *Compressor, value cacheValue) ([]byte, error) {
+	return encode(valueCodec, c, value.Request.Method, value)
+}
+
+// decodeValue decodes cache values of every codec
+func decodeValue(data []byte, value *cacheValue) error {
+	if err := decode(data, value); err != nil {
+		return err
+	}
+	value.narrowNumbers()
+	return nil
+}
+
+func encodeIndex(valueCodec codec.Codec, value indexValue) ([]byte, error) {
+	return encode(valueCodec, nil, value.Request.Method, value)
+}
+
+func decodeIndex(data []byte, value *indexValue) error {
+	if err := decode(data, value); err != nil {
+		return err
+	}
+	narrowRequestNumbers(&value.Request)
+	return nil
+}
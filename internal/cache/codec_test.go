@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/codec"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestEncodeValueCodecs(t *testing.T) {
+	value := compressionTestValue("compressed")
+	compressor := NewCompressor(config.GzipCompression, 6, 0, "compressed")
+	for _, valueCodec := range []codec.Codec{codec.JSON{}, codec.CBOR{}, codec.MessagePack{}} {
+		for _, c := range []*Compressor{nil, compressor} {
+			data, err := encodeValue(valueCodec, c, value)
+			require.NoError(t, err)
+			require.Equal(t, c != nil, isCompressed(data))
+			decoded := cacheValue{}
+			require.NoError(t, decodeValue(data, &decoded))
+			require.Equal(t, value, decoded, valueCodec.Name())
+		}
+	}
+}
+
+func TestDecodeLegacyValues(t *testing.T) {
+	value := compressionTestValue("compressed")
+	plain, err := bson.Marshal(value)
+	require.NoError(t, err)
+	decoded := cacheValue{}
+	require.NoError(t, decodeValue(plain, &decoded))
+	require.Equal(t, value, decoded)
+
+	buf := bytes.NewBuffer(append(append([]byte(nil), legacyValueMagic...), compressionGzip))
+	writer := gzip.NewWriter(buf)
+	_, err = writer.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	require.True(t, isCompressed(buf.Bytes()))
+	decoded = cacheValue{}
+	require.NoError(t, decodeValue(buf.Bytes(), &decoded))
+	require.Equal(t, value, decoded)
+
+	// bson values with the header
+	header := append(append([]byte(nil), valueMagic...), codec.BSONID, compressionNone)
+	decoded = cacheValue{}
+	require.NoError(t, decodeValue(append(header, plain...), &decoded))
+	require.Equal(t, value, decoded)
+	_, err = encodeValue(codec.BSON{}, nil, value)
+	require.Error(t, err)
+}
+
+func TestDiskCacheCodecChange(t *testing.T) {
+	disk, err := NewDiskCache(filepath.Join(t.TempDir(), "cache.db"))
+	require.NoError(t, err)
+	defer disk.Close()
+	reqs, resps := lruTestValues(2)
+	disk.SetCodec(codec.CBOR{})
+	require.NoError(t, disk.Set("0", reqs[0], resps[0], 0, 0))
+	disk.SetCodec(codec.MessagePack{})
+	require.NoError(t, disk.Set("1", reqs[1], resps[1], 0, 0))
+
+	for idx := range reqs {
+		entry, ok, err := disk.GetEntry(fmt.Sprint(idx))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, reqs[idx], entry.Request)
+		require.Equal(t, resps[idx], entry.Response)
+	}
+}
@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/metrics"
+)
+
+const (
+	compressionNone byte = iota
+	compressionGzip
+)
+
+// Compressor compresses encoded cache values
+type Compressor struct {
+	algorithm config.CompressionAlgorithm
+	level     int
+	minSize   int
+	// exact method name => compress. exact names take precedence over patterns as in the matcher
+	methods  map[string]bool
+	patterns []*compressPattern
+}
+
+// compressPattern is the method pattern of rules, a glob or a regular expression
+type compressPattern struct {
+	pattern  string
+	re       *regexp.Regexp
+	compress bool
+}
+
+func (p compressPattern) match(method string) bool {
+	if p.re != nil {
+		return p.re.MatchString(method)
+	}
+	ok, _ := path.Match(p.pattern, method)
+	return ok
+}
+
+// NewCompressor initializes compressor. Values of methods and values of at least minSize bytes are compressed.
+// methods may be patterns as names of rules. Zero minSize disables compression by size
+func NewCompressor(algorithm config.CompressionAlgorithm, level, minSize int, methods ...string) *Compressor {
+	c := &Compressor{
+		algorithm: algorithm,
+		level:     level,
+		minSize:   minSize,
+		methods:   make(map[string]bool, len(methods)),
+	}
+	for _, method := range methods {
+		c.addMethod(config.CacheMethod{Name: method, Compress: true})
+	}
+	return c
+}
+
+// NewCompressorFromConfig initializes compressor from config. nil means compression is disabled
+func NewCompressorFromConfig(c *config.Config) *Compressor {
+	settings := c.CacheSettings.Compression
+	if !settings.Algorithm.IsEnabled() {
+		return nil
+	}
+	compressor := NewCompressor(settings.Algorithm, settings.Level, settings.MinSize)
+	for _, method := range c.CacheMethods {
+		if method.Enabled {
+			compressor.addMethod(method)
+		}
+	}
+	return compressor
+}
+
+// addMethod adds the rule. Rules without compress are added as well, so they take precedence as in the matcher
+func (c *Compressor) addMethod(method config.CacheMethod) {
+	if !method.IsPattern() {
+		c.methods[method.Name] = c.methods[method.Name] || method.Compress
+		return
+	}
+	for _, p := range c.patterns {
+		if p.pattern == method.Name {
+			p.compress = p.compress || method.Compress
+			return
+		}
+	}
+	p := &compressPattern{pattern: method.Name, compress: method.Compress}
+	if method.IsRegexp() {
+		re, err := method.Regexp()
+		if err != nil {
+			logger.Log.Error(err)
+			return
+		}
+		p.re = re
+	}
+	c.patterns = append(c.patterns, p)
+}
+
+// compressMethod reports whether values of the method are compressed regardless of their size
+func (c *Compressor) compressMethod(method string) bool {
+	if compress, ok := c.methods[method]; ok {
+		return compress
+	}
+	for _, p := range c.patterns {
+		if p.match(method) {
+			return p.compress
+		}
+	}
+	return false
+}
+
+func (c *Compressor) shouldCompress(method string, size int) bool {
+	if c == nil {
+		return false
+	}
+	if c.compressMethod(method) {
+		return true
+	}
+	return c.minSize > 0 && size >= c.minSize
+}
+
+// estimateSize estimates JSON encoded size of the value decoded from JSON. Estimation stops at the limit,
+// so it is cheap for large values. Values of other types are estimated at the limit
+func estimateSize(value interface{}, limit int) int {
+	size := 0
+	var walk func(interface{}) bool
+	walk = func(value interface{}) bool {
+		switch v := value.(type) {
+		case nil, bool, float64:
+			size += 5
+		case string:
+			size += len(v) + 2
+		case json.Number:
+			size += len(v)
+		case []interface{}:
+			size += 2
+			for _, item := range v {
+				if !walk(item) {
+					return false
+				}
+				size++
+			}
+		case map[string]interface{}:
+			size += 2
+			for key, item := range v {
+				size += len(key) + 4
+				if !walk(item) {
+					return false
+				}
+			}
+		default:
+			size = limit
+		}
+		return size < limit
+	}
+	walk(value)
+	return size
+}
+
+// compress writes the compressed data to buf
+func (c *Compressor) compress(method string, buf *bytes.Buffer, data []byte) error {
+	start := buf.Len()
+	writer, err := gzip.NewWriterLevel(buf, c.level)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	metrics.SetCacheCompressedBytes(method, len(data), buf.Len()-start)
+	return nil
+}
+
+func decompress(compression byte, data []byte) ([]byte, error) {
+	switch compression {
+	case compressionNone:
+		return data, nil
+	case compressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	default:
+		return nil, fmt.Errorf("unknown cache value compression: %d", compression)
+	}
+}
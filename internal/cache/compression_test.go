@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+
+	"github.com/stretchr/testify/require"
+)
+
+func compressionTestValue(method string) cacheValue {
+	return newCacheValue(
+		requests.RPCRequest{
+			JSONRPC: "2.0",
+			ID:      float64(1),
+			Method:  method,
+		},
+		requests.RPCResponse{
+			JSONRPC: "2.0",
+			ID:      float64(1),
+			Result:  strings.Repeat("deal", 1000),
+		},
+		0, 0,
+	)
+}
+
+func TestEncodeValueCompression(t *testing.T) {
+	compressor := NewCompressor(config.GzipCompression, 6, 0, "compressed")
+
+	plain, err := encodeValue(nil, compressor, compressionTestValue("plain"))
+	require.NoError(t, err)
+	require.False(t, isCompressed(plain))
+
+	value := compressionTestValue("compressed")
+	compressed, err := encodeValue(nil, compressor, value)
+	require.NoError(t, err)
+	require.True(t, isCompressed(compressed))
+	require.Less(t, len(compressed), len(plain))
+
+	decoded := cacheValue{}
+	require.NoError(t, decodeValue(compressed, &decoded))
+	require.Equal(t, value, decoded)
+	decoded = cacheValue{}
+	require.NoError(t, decodeValue(plain, &decoded))
+	require.Equal(t, compressionTestValue("plain"), decoded)
+}
+
+func TestCompressorMethodPatterns(t *testing.T) {
+	compressor := NewCompressorFromConfig(&config.Config{
+		CacheSettings: config.CacheSettings{Compression: config.CompressionSettings{Algorithm: config.GzipCompression, Level: 6}},
+		CacheMethods: []config.CacheMethod{
+			{Name: "Filecoin.StateMarketParticipants", Enabled: true},
+			{Name: "Filecoin.StateMarket*", Enabled: true, Compress: true},
+			{Name: "/^eth_get.*$/", Enabled: true, Compress: true},
+			{Name: "eth_*", Enabled: true},
+		},
+	})
+	for method, compress := range map[string]bool{
+		"Filecoin.StateMarketDeals":        true,
+		"Filecoin.StateMarketParticipants": false,
+		"eth_getBlockByNumber":             true,
+		"eth_call":                         false,
+		"Filecoin.ChainHead":               false,
+	} {
+		require.Equal(t, compress, compressor.shouldCompress(method, 0), method)
+	}
+}
+
+func TestEncodeValueCompressionMinSize(t *testing.T) {
+	value := compressionTestValue("plain")
+	data, err := encodeValue(nil, NewCompressor(config.GzipCompression, 6, 1024), value)
+	require.NoError(t, err)
+	require.True(t, isCompressed(data))
+	data, err = encodeValue(nil, NewCompressor(config.GzipCompression, 6, 1<<20), value)
+	require.NoError(t, err)
+	require.False(t, isCompressed(data))
+}
+
+func TestMemoryCacheCompression(t *testing.T) {
+	value := compressionTestValue("compressed")
+	for _, cache := range []interface {
+		Cache
+		SetCompressor(*Compressor)
+	}{NewMemoryCacheDefault(), NewLRUCache(0, 0, 0)} {
+		cache.SetCompressor(NewCompressor(config.GzipCompression, 6, 0, "compressed"))
+		require.NoError(t, cache.Set("1", value.Request, value.Response, 0, 0))
+		response, err := cache.Get("1")
+		require.NoError(t, err)
+		require.Equal(t, value.Response, response)
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	value := map[string]interface{}{
+		"Deals": []interface{}{"deal", float64(1), nil, json.Number("10")},
+	}
+	data, err := json.Marshal(value)
+	require.NoError(t, err)
+	size := estimateSize(value, 1<<20)
+	require.InDelta(t, len(data), size, float64(len(data))/2)
+	deals := make([]interface{}, 1000)
+	for idx := range deals {
+		deals[idx] = "deal"
+	}
+	// estimation stops at the limit
+	require.Less(t, estimateSize(deals, 10), 20)
+	require.Equal(t, 1024, estimateSize(struct{}{}, 1024))
+
+	// values below min_size are not encoded
+	compressor := NewCompressor(config.GzipCompression, 6, 1024)
+	small, err := newMemoryValue(nil, compressor, newCacheValue(
+		requests.RPCRequest{Method: "plain"}, requests.RPCResponse{Result: "deal"}, 0, 0,
+	))
+	require.NoError(t, err)
+	require.Nil(t, small.data)
+	large, err := newMemoryValue(nil, compressor, compressionTestValue("plain"))
+	require.NoError(t, err)
+	require.NotNil(t, large.data)
+}
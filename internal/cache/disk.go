@@ -0,0 +1,307 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/codec"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/metrics"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	diskOpenTimeout = 5 * time.Second
+	// index entries checked within one write transaction, so cleanup does not block writes for long
+	diskCleanupBatch = 1000
+)
+
+var (
+	diskBucket = []byte("values")
+	// requests are indexed apart from the values, so they are scanned without reading responses
+	diskIndexBucket = []byte("requests")
+)
+
+// DiskCache keeps cached values in the embedded bbolt database.
+// Expired values are not served and are removed periodically
+type DiskCache struct {
+	db         *bolt.DB
+	compressor *Compressor
+	codec      codec.Codec
+}
+
+// NewDiskCache opens or creates the cache database
+func NewDiskCache(path string) (*DiskCache, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: diskOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		values, err := tx.CreateBucketIfNotExists(diskBucket)
+		if err != nil {
+			return err
+		}
+		if tx.Bucket(diskIndexBucket) != nil {
+			return nil
+		}
+		index, err := tx.CreateBucket(diskIndexBucket)
+		if err != nil {
+			return err
+		}
+		return rebuildDiskIndex(values, index)
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &DiskCache{db: db}, nil
+}
+
+// rebuildDiskIndex indexes values stored before the index was introduced
+func rebuildDiskIndex(values, index *bolt.Bucket) error {
+	return values.ForEach(func(key, data []byte) error {
+		value := cacheValue{}
+		if err := decodeValue(data, &value); err != nil {
+			return err
+		}
+		indexData, err := encodeIndex(nil, value.index())
+		if err != nil {
+			return err
+		}
+		return index.Put(key, indexData)
+	})
+}
+
+// NewDiskCacheFromConfig opens the cache database and starts removing expired values
+func NewDiskCacheFromConfig(ctx context.Context, settings config.DiskCacheSettings) (*DiskCache, error) {
+	disk, err := NewDiskCache(settings.Path)
+	if err != nil {
+		return nil, err
+	}
+	if settings.CleanupInterval > 0 {
+		go disk.Start(ctx, settings.CleanupInterval)
+	}
+	return disk, nil
+}
+
+// SetCompressor enables compression of cached values
+func (d *DiskCache) SetCompressor(c *Compressor) {
+	d.compressor = c
+}
+
+// SetCodec sets the codec of cached values
+func (d *DiskCache) SetCodec(c codec.Codec) {
+	d.codec = c
+}
+
+// Start periodically removes expired values until the context is done
+func (d *DiskCache) Start(ctx context.Context, period int) {
+	ticker := time.NewTicker(time.Second * time.Duration(period))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.removeExpired(); err != nil {
+				logger.Log.Errorf("Cannot remove expired disk cache values: %v", err)
+			}
+		}
+	}
+}
+
+func (d *DiskCache) removeExpired() error {
+	now := time.Now()
+	var from []byte
+	for {
+		next, err := d.removeExpiredBatch(from, now)
+		if err != nil || next == nil {
+			return err
+		}
+		from = next
+	}
+}
+
+// removeExpiredBatch removes expired values of diskCleanupBatch index entries starting from the key.
+// It returns the key to continue from. nil means the whole index is checked
+func (d *DiskCache) removeExpiredBatch(from []byte, now time.Time) ([]byte, error) {
+	var next []byte
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		values, index := tx.Bucket(diskBucket), tx.Bucket(diskIndexBucket)
+		cursor := index.Cursor()
+		key, data := cursor.First()
+		if from != nil {
+			key, data = cursor.Seek(from)
+		}
+		var expired [][]byte
+		// buckets cannot be modified while iterating, keys are copied to delete them afterwards
+		for checked := 0; key != nil; key, data = cursor.Next() {
+			if checked == diskCleanupBatch {
+				next = append([]byte(nil), key...)
+				break
+			}
+			checked++
+			value := indexValue{}
+			if err := decodeIndex(data, &value); err != nil {
+				logger.Log.Errorf("Cannot decode disk cache request %q: %v", key, err)
+				expired = append(expired, append([]byte(nil), key...))
+				continue
+			}
+			if value.isExpired(now) {
+				expired = append(expired, append([]byte(nil), key...))
+			}
+		}
+		for _, key := range expired {
+			if err := values.Delete(key); err != nil {
+				return err
+			}
+			if err := index.Delete(key); err != nil {
+				return err
+			}
+		}
+		metrics.SetCacheSize(int64(values.Stats().KeyN))
+		return nil
+	})
+	return next, err
+}
+
+// Set ...
+func (d *DiskCache) Set(key string, request requests.RPCRequest, response requests.RPCResponse, ttl, maxStale time.Duration) error {
+	value := newCacheValue(request, response, ttl, maxStale)
+	data, err := encodeValue(d.codec, d.compressor, value)
+	if err != nil {
+		return err
+	}
+	indexData, err := encodeIndex(d.codec, value.index())
+	if err != nil {
+		return err
+	}
+	return d.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(diskBucket).Put([]byte(key), data); err != nil {
+			return err
+		}
+		return tx.Bucket(diskIndexBucket).Put([]byte(key), indexData)
+	})
+}
+
+// Get ...
+func (d *DiskCache) Get(key string) (requests.RPCResponse, error) {
+	response, stale, err := d.GetStale(key)
+	if err != nil || stale {
+		return requests.RPCResponse{}, err
+	}
+	return response, nil
+}
+
+// GetStale ...
+func (d *DiskCache) GetStale(key string) (requests.RPCResponse, bool, error) {
+	value := cacheValue{}
+	found := false
+	err := d.db.View(func(tx *bolt.Tx) error {
+		// data is valid only within the transaction, so it is decoded here
+		data := tx.Bucket(diskBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return decodeValue(data, &value)
+	})
+	now := time.Now()
+	if err != nil || !found || value.isExpired(now) {
+		return requests.RPCResponse{}, false, err
+	}
+	return value.Response, !value.isFresh(now), nil
+}
+
+// Delete ...
+func (d *DiskCache) Delete(key string) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(diskBucket).Delete([]byte(key)); err != nil {
+			return err
+		}
+		return tx.Bucket(diskIndexBucket).Delete([]byte(key))
+	})
+}
+
+// ScanRequests reads the request index only
+func (d *DiskCache) ScanRequests(fn func(key string, request requests.RPCRequest) error) error {
+	now := time.Now()
+	return d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(diskIndexBucket).ForEach(func(key, data []byte) error {
+			value := indexValue{}
+			if err := decodeIndex(data, &value); err != nil {
+				return err
+			}
+			if value.isExpired(now) {
+				return nil
+			}
+			return fn(string(key), value.Request)
+		})
+	})
+}
+
+// Entries reads the request index and sizes of values, values are not decoded
+func (d *DiskCache) Entries() ([]Entry, error) {
+	var res []Entry
+	now := time.Now()
+	err := d.db.View(func(tx *bolt.Tx) error {
+		values := tx.Bucket(diskBucket)
+		return tx.Bucket(diskIndexBucket).ForEach(func(key, data []byte) error {
+			value := indexValue{}
+			if err := decodeIndex(data, &value); err != nil {
+				return err
+			}
+			if value.isExpired(now) {
+				return nil
+			}
+			size := len(values.Get(key))
+			if size == 0 {
+				return nil
+			}
+			res = append(res, newEntry(string(key), value.cacheValue(), size))
+			return nil
+		})
+	})
+	return res, err
+}
+
+// GetEntry ...
+func (d *DiskCache) GetEntry(key string) (Entry, bool, error) {
+	value := cacheValue{}
+	size := 0
+	err := d.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(diskBucket).Get([]byte(key))
+		size = len(data)
+		if data == nil {
+			return nil
+		}
+		return decodeValue(data, &value)
+	})
+	if err != nil || size == 0 || value.isExpired(time.Now()) {
+		return Entry{}, false, err
+	}
+	return newEntry(key, value, size), true, nil
+}
+
+// Close ...
+func (d *DiskCache) Close() error {
+	return d.db.Close()
+}
+
+// Clean ...
+func (d *DiskCache) Clean() error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{diskBucket, diskIndexBucket} {
+			if err := tx.DeleteBucket(bucket); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
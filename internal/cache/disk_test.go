@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestDiskCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	disk, err := NewDiskCache(path)
+	require.NoError(t, err)
+	reqs, resps := lruTestValues(3)
+	require.NoError(t, disk.Set("0", reqs[0], resps[0], 0, 0))
+	require.NoError(t, disk.Set("1", reqs[1], resps[1], time.Millisecond, 0))
+	require.NoError(t, disk.Set("2", reqs[2], resps[2], 0, 0))
+	require.NoError(t, disk.Delete("2"))
+	time.Sleep(2 * time.Millisecond)
+
+	value, err := disk.Get("0")
+	require.NoError(t, err)
+	require.Equal(t, resps[0], value)
+	for _, key := range []string{"1", "2", "3"} {
+		value, err = disk.Get(key)
+		require.NoError(t, err)
+		require.True(t, value.IsEmpty())
+	}
+	cachedReqs, err := Requests(disk)
+	require.NoError(t, err)
+	require.Equal(t, reqs[:1], cachedReqs)
+	entries, err := disk.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "0", entries[0].Key)
+	require.NotZero(t, entries[0].Size)
+
+	// values survive reopening
+	require.NoError(t, disk.removeExpired())
+	require.NoError(t, disk.Close())
+	disk, err = NewDiskCache(path)
+	require.NoError(t, err)
+	value, err = disk.Get("0")
+	require.NoError(t, err)
+	require.Equal(t, resps[0], value)
+
+	require.NoError(t, disk.Clean())
+	cachedReqs, err = Requests(disk)
+	require.NoError(t, err)
+	require.Empty(t, cachedReqs)
+	require.NoError(t, disk.Close())
+}
+
+func TestDiskCacheRebuildsIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	disk, err := NewDiskCache(path)
+	require.NoError(t, err)
+	reqs, resps := lruTestValues(2)
+	require.NoError(t, disk.Set("0", reqs[0], resps[0], 0, 0))
+	require.NoError(t, disk.Set("1", reqs[1], resps[1], 0, 0))
+	// databases created before the index have values only
+	require.NoError(t, disk.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(diskIndexBucket)
+	}))
+	require.NoError(t, disk.Close())
+
+	disk, err = NewDiskCache(path)
+	require.NoError(t, err)
+	defer disk.Close()
+	cachedReqs, err := Requests(disk)
+	require.NoError(t, err)
+	require.ElementsMatch(t, reqs, cachedReqs)
+}
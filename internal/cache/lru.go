@@ -0,0 +1,320 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/codec"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/metrics"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+)
+
+type lruEntry struct {
+	key     string
+	value   memoryValue
+	size    int64
+	expires int64
+}
+
+func (e *lruEntry) isExpired(now time.Time) bool {
+	return e.expires != 0 && now.UnixNano() > e.expires
+}
+
+// LRUCache is memory cache bounded by number of entries and their encoded size
+type LRUCache struct {
+	lock              sync.Mutex
+	maxBytes          int64
+	maxEntries        int
+	defaultExpiration time.Duration
+	items             map[string]*list.Element
+	order             *list.List
+	bytes             int64
+	compressor        *Compressor
+	codec             codec.Codec
+}
+
+// NewLRUCache initializes LRU memory cache. Zero limit means no limit
+func NewLRUCache(maxBytes int64, maxEntries int, defaultExpiration time.Duration) *LRUCache {
+	return &LRUCache{
+		maxBytes:          maxBytes,
+		maxEntries:        maxEntries,
+		defaultExpiration: defaultExpiration,
+		items:             make(map[string]*list.Element),
+		order:             list.New(),
+	}
+}
+
+// NewLRUCacheFromConfig initializes LRU memory cache from config and starts removing expired entries
+func NewLRUCacheFromConfig(ctx context.Context, config config.MemoryCacheSettings) *LRUCache {
+	lru := NewLRUCache(
+		config.MaxBytes,
+		config.MaxEntries,
+		time.Duration(config.DefaultExpiration)*time.Second,
+	)
+	if config.CleanupInterval > 0 {
+		go lru.Start(ctx, config.CleanupInterval)
+	}
+	return lru
+}
+
+// Start periodically removes expired entries until the context is done.
+// Otherwise expired entries are removed only when they are read or evicted
+func (l *LRUCache) Start(ctx context.Context, period int) {
+	ticker := time.NewTicker(time.Second * time.Duration(period))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.removeExpired()
+		}
+	}
+}
+
+func (l *LRUCache) removeExpired() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	for elem := l.order.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*lruEntry).isExpired(now) {
+			l.remove(elem)
+		}
+		elem = next
+	}
+	l.report()
+}
+
+// SetCompressor enables compression of cached values
+func (l *LRUCache) SetCompressor(c *Compressor) {
+	l.compressor = c
+}
+
+// SetCodec sets the codec of cached values
+func (l *LRUCache) SetCodec(c codec.Codec) {
+	l.codec = c
+}
+
+// valueSize returns encoded size of cache value
+func valueSize(value memoryValue) (int64, error) {
+	request, err := json.Marshal(value.Request)
+	if err != nil {
+		return 0, err
+	}
+	if value.data != nil {
+		return int64(len(request) + len(value.data)), nil
+	}
+	response, err := json.Marshal(value.Response)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(request) + len(response)), nil
+}
+
+// Set ...
+func (l *LRUCache) Set(key string, request requests.RPCRequest, response requests.RPCResponse, ttl, maxStale time.Duration) error {
+	value, err := newMemoryValue(l.codec, l.compressor, newCacheValue(request, response, ttl, maxStale))
+	if err != nil {
+		return err
+	}
+	size, err := valueSize(value)
+	if err != nil {
+		return err
+	}
+	if l.maxBytes > 0 && size > l.maxBytes {
+		return Error{message: fmt.Sprintf("cache value size %d exceeds max_bytes %d", size, l.maxBytes)}
+	}
+	entry := &lruEntry{
+		key:   key,
+		value: value,
+		size:  size,
+	}
+	if keep := retention(ttl, maxStale); keep > 0 {
+		entry.expires = time.Now().Add(keep).UnixNano()
+	} else if l.defaultExpiration > 0 {
+		entry.expires = time.Now().Add(l.defaultExpiration).UnixNano()
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.insert(entry)
+	l.report()
+	return nil
+}
+
+// insert adds the entry as the most recently used one and evicts entries exceeding the limits
+func (l *LRUCache) insert(entry *lruEntry) {
+	if elem, ok := l.items[entry.key]; ok {
+		l.remove(elem)
+	}
+	l.items[entry.key] = l.order.PushFront(entry)
+	l.bytes += entry.size
+	evicted := l.evict()
+	if evicted > 0 {
+		metrics.SetCacheEvictionsCounter(evicted)
+	}
+}
+
+// evict removes least recently used entries until the cache fits its limits
+func (l *LRUCache) evict() int {
+	evicted := 0
+	for l.order.Len() > 0 && ((l.maxEntries > 0 && l.order.Len() > l.maxEntries) || (l.maxBytes > 0 && l.bytes > l.maxBytes)) {
+		l.remove(l.order.Back())
+		evicted++
+	}
+	return evicted
+}
+
+func (l *LRUCache) remove(elem *list.Element) {
+	entry := l.order.Remove(elem).(*lruEntry)
+	delete(l.items, entry.key)
+	l.bytes -= entry.size
+}
+
+func (l *LRUCache) report() {
+	metrics.SetCacheSize(int64(l.order.Len()))
+	metrics.SetCacheBytes(l.bytes)
+}
+
+// Get ...
+func (l *LRUCache) Get(key string) (requests.RPCResponse, error) {
+	response, stale, err := l.GetStale(key)
+	if err != nil || stale {
+		return requests.RPCResponse{}, err
+	}
+	return response, nil
+}
+
+// GetStale ...
+func (l *LRUCache) GetStale(key string) (requests.RPCResponse, bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	elem, ok := l.items[key]
+	if !ok {
+		return requests.RPCResponse{}, false, nil
+	}
+	entry := elem.Value.(*lruEntry)
+	now := time.Now()
+	if entry.isExpired(now) {
+		l.remove(elem)
+		l.report()
+		return requests.RPCResponse{}, false, nil
+	}
+	l.order.MoveToFront(elem)
+	response, err := entry.value.response()
+	return response, !entry.value.isFresh(now), err
+}
+
+// Delete ...
+func (l *LRUCache) Delete(key string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if elem, ok := l.items[key]; ok {
+		l.remove(elem)
+		l.report()
+	}
+	return nil
+}
+
+// ScanRequests calls fn outside of the lock
+func (l *LRUCache) ScanRequests(fn func(key string, request requests.RPCRequest) error) error {
+	l.lock.Lock()
+	now := time.Now()
+	keys := make([]string, 0, l.order.Len())
+	reqs := make([]requests.RPCRequest, 0, l.order.Len())
+	for elem := l.order.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*lruEntry)
+		if entry.isExpired(now) {
+			continue
+		}
+		keys = append(keys, entry.key)
+		reqs = append(reqs, entry.value.Request)
+	}
+	l.lock.Unlock()
+	for idx := range keys {
+		if err := fn(keys[idx], reqs[idx]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Entries ...
+func (l *LRUCache) Entries() ([]Entry, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	res := make([]Entry, 0, l.order.Len())
+	for elem := l.order.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*lruEntry)
+		if entry.isExpired(now) {
+			continue
+		}
+		value := entry.value.cacheValue
+		value.Response = requests.RPCResponse{}
+		res = append(res, newEntry(entry.key, value, int(entry.size)))
+	}
+	return res, nil
+}
+
+// GetEntry does not affect the entry recency
+func (l *LRUCache) GetEntry(key string) (Entry, bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	elem, ok := l.items[key]
+	if !ok || elem.Value.(*lruEntry).isExpired(time.Now()) {
+		return Entry{}, false, nil
+	}
+	return elem.Value.(*lruEntry).value.entry(key)
+}
+
+// entries returns unexpired entries from the least to the most recently used
+func (l *LRUCache) entries() []snapshotEntry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	res := make([]snapshotEntry, 0, l.order.Len())
+	for elem := l.order.Back(); elem != nil; elem = elem.Prev() {
+		entry := elem.Value.(*lruEntry)
+		if entry.isExpired(now) {
+			continue
+		}
+		res = append(res, snapshotEntry{key: entry.key, value: entry.value, expires: entry.expires})
+	}
+	return res
+}
+
+func (l *LRUCache) load(entries []snapshotEntry) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, entry := range entries {
+		size, err := valueSize(entry.value)
+		if err != nil || (l.maxBytes > 0 && size > l.maxBytes) {
+			continue
+		}
+		l.insert(&lruEntry{key: entry.key, value: entry.value, size: size, expires: entry.expires})
+	}
+	l.report()
+}
+
+// Close ...
+func (l *LRUCache) Close() error {
+	return l.Clean()
+}
+
+// Clean ...
+func (l *LRUCache) Clean() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.items = make(map[string]*list.Element)
+	l.order.Init()
+	l.bytes = 0
+	l.report()
+	return nil
+}
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+
+	"github.com/stretchr/testify/require"
+)
+
+func lruTestValues(n int) ([]requests.RPCRequest, []requests.RPCResponse) {
+	reqs := make([]requests.RPCRequest, n)
+	resps := make([]requests.RPCResponse, n)
+	for i := 0; i < n; i++ {
+		reqs[i] = requests.RPCRequest{
+			JSONRPC: "2.0",
+			ID:      float64(i),
+			Method:  "test",
+			Params:  []interface{}{fmt.Sprintf("%d", i)},
+		}
+		resps[i] = requests.RPCResponse{
+			JSONRPC: "2.0",
+			ID:      float64(i),
+			Result:  "result",
+		}
+	}
+	return reqs, resps
+}
+
+func TestLRUCacheMaxEntries(t *testing.T) {
+	cache := NewLRUCache(0, 2, 0)
+	reqs, resps := lruTestValues(3)
+	require.NoError(t, cache.Set("0", reqs[0], resps[0], 0, 0))
+	require.NoError(t, cache.Set("1", reqs[1], resps[1], 0, 0))
+	// "0" becomes the most recently used entry
+	value, err := cache.Get("0")
+	require.NoError(t, err)
+	require.Equal(t, resps[0], value)
+	require.NoError(t, cache.Set("2", reqs[2], resps[2], 0, 0))
+
+	value, err = cache.Get("1")
+	require.NoError(t, err)
+	require.True(t, value.IsEmpty())
+	for _, key := range []string{"0", "2"} {
+		value, err = cache.Get(key)
+		require.NoError(t, err)
+		require.False(t, value.IsEmpty())
+	}
+	cachedReqs, err := Requests(cache)
+	require.NoError(t, err)
+	require.Len(t, cachedReqs, 2)
+}
+
+func TestLRUCacheMaxBytes(t *testing.T) {
+	reqs, resps := lruTestValues(3)
+	size, err := valueSize(memoryValue{cacheValue: newCacheValue(reqs[0], resps[0], 0, 0)})
+	require.NoError(t, err)
+	cache := NewLRUCache(size*2, 0, 0)
+	for i := range reqs {
+		require.NoError(t, cache.Set(fmt.Sprintf("%d", i), reqs[i], resps[i], 0, 0))
+	}
+	require.Equal(t, size*2, cache.bytes)
+	value, err := cache.Get("0")
+	require.NoError(t, err)
+	require.True(t, value.IsEmpty())
+
+	tooLarge := NewLRUCache(size-1, 0, 0)
+	err = tooLarge.Set("0", reqs[0], resps[0], 0, 0)
+	require.Error(t, err)
+	require.IsType(t, Error{}, err)
+}
+
+func TestLRUCacheTTL(t *testing.T) {
+	ttl := 500 * time.Millisecond
+	cache := NewLRUCache(0, 10, 0)
+	reqs, resps := lruTestValues(1)
+	require.NoError(t, cache.Set("0", reqs[0], resps[0], ttl, 0))
+	time.Sleep(ttl + ttl/2)
+	value, err := cache.Get("0")
+	require.NoError(t, err)
+	require.True(t, value.IsEmpty())
+	require.Equal(t, 0, cache.order.Len())
+}
+
+func TestLRUCacheRemoveExpired(t *testing.T) {
+	cache := NewLRUCache(0, 10, 0)
+	reqs, resps := lruTestValues(2)
+	require.NoError(t, cache.Set("0", reqs[0], resps[0], time.Millisecond, 0))
+	require.NoError(t, cache.Set("1", reqs[1], resps[1], 0, 0))
+	time.Sleep(2 * time.Millisecond)
+	cache.removeExpired()
+	require.Equal(t, 1, cache.order.Len())
+	_, ok := cache.items["1"]
+	require.True(t, ok)
+}
+
+func TestLRUCacheEntries(t *testing.T) {
+	cache := NewLRUCache(0, 0, 0)
+	reqs, resps := lruTestValues(2)
+	require.NoError(t, cache.Set("0", reqs[0], resps[0], time.Minute, time.Hour))
+	require.NoError(t, cache.Set("1", reqs[1], resps[1], 0, 0))
+
+	entries, err := cache.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		require.Equal(t, "test", entry.Request.Method)
+		require.Greater(t, entry.Size, 0)
+	}
+
+	entry, ok, err := cache.GetEntry("0")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, reqs[0], entry.Request)
+	require.Equal(t, resps[0], entry.Response)
+	require.False(t, entry.IsStale(time.Now()))
+	require.True(t, entry.IsStale(time.Now().Add(2*time.Minute)))
+
+	_, ok, err = cache.GetEntry("2")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
@@ -3,9 +3,17 @@ package cache
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/protofire/filecoin-rpc-proxy/internal/codec"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
 	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
 
 	"gopkg.in/mgo.v2/bson"
@@ -15,91 +23,512 @@ import (
 	"github.com/go-redis/redis/v8"
 )
 
-const hashMapName = "filecoin"
+const (
+	// legacyHashMapName is a redis hash where all values were stored before per-key storage
+	legacyHashMapName = "filecoin"
+	// requestIndexName is a redis hash in the namespace where cached requests are indexed apart from the values
+	requestIndexName = "__requests__"
+	scanCount        = 1000
+)
 
 // Client represents redis client
 type Client struct {
-	*redis.Client
+	redis.UniversalClient
+	prefix     string
+	compressor *Compressor
+	codec      codec.Codec
+	// serializes scan callbacks for concurrently scanned cluster nodes
+	scanLock sync.Mutex
+}
+
+// newTLSConfig builds redis TLS configuration. nil means TLS is disabled
+func newTLSConfig(settings config.RedisTLSSettings, enabled bool, serverName string) (*tls.Config, error) {
+	if !enabled && !settings.Enabled {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: settings.InsecureSkipVerify, // nolint
+		MinVersion:         tls.VersionTLS12,
+	}
+	if settings.ServerName != "" {
+		tlsConfig.ServerName = settings.ServerName
+	}
+	if settings.CAFile != "" {
+		ca, err := ioutil.ReadFile(settings.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read redis ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("cannot parse redis ca file: %s", settings.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if settings.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load redis client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func newUniversalClient(ctx context.Context, settings config.RedisCacheSettings) (redis.UniversalClient, error) {
+	switch settings.Mode {
+	case config.RedisSentinelMode:
+		tlsConfig, err := newTLSConfig(settings.TLS, false, "")
+		if err != nil {
+			return nil, err
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       settings.MasterName,
+			SentinelAddrs:    settings.Addresses,
+			SentinelPassword: settings.SentinelPassword,
+			Username:         settings.Username,
+			Password:         settings.Password,
+			DB:               settings.DB,
+			PoolSize:         settings.PoolSize,
+			TLSConfig:        tlsConfig,
+		}).WithContext(ctx), nil
+	case config.RedisClusterMode:
+		tlsConfig, err := newTLSConfig(settings.TLS, false, "")
+		if err != nil {
+			return nil, err
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     settings.Addresses,
+			Username:  settings.Username,
+			Password:  settings.Password,
+			PoolSize:  settings.PoolSize,
+			TLSConfig: tlsConfig,
+		}).WithContext(ctx), nil
+	default:
+		uri, err := url.Parse(settings.URI)
+		if err != nil {
+			return nil, err
+		}
+		opts, err := redis.ParseURL(settings.URI)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig, err := newTLSConfig(settings.TLS, uri.Scheme == "rediss", uri.Hostname())
+		if err != nil {
+			return nil, err
+		}
+		opts.PoolSize = settings.PoolSize
+		opts.TLSConfig = tlsConfig
+		return redis.NewClient(opts).WithContext(ctx), nil
+	}
 }
 
 // NewRedisClient creates redis client
 func NewRedisClient(ctx context.Context, config config.RedisCacheSettings) (*Client, error) {
-	var opts *redis.Options
-	var err error
-	var tlsConfig *tls.Config
-	if strings.HasSuffix(config.URI, "rediss") {
-		tlsConfig = &tls.Config{
-			InsecureSkipVerify: true, // nolint
+	client, err := newUniversalClient(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = client.Ping(client.Context()).Result(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("cannot initialize redis client: %w", err)
+	}
+	c := &Client{
+		UniversalClient: client,
+		prefix:          config.Prefix,
+	}
+	if config.MigrateLegacyHash {
+		if err := c.migrateLegacyHash(); err != nil {
+			_ = client.Close()
+			return nil, fmt.Errorf("cannot migrate legacy redis hash: %w", err)
 		}
 	}
-	opts, err = redis.ParseURL(config.URI)
+	if err := c.ensureIndex(); err != nil {
+		logger.Log.Errorf("Cannot index cached redis requests: %v", err)
+	}
+	return c, nil
+}
+
+// SetCompressor enables compression of cached values
+func (client *Client) SetCompressor(c *Compressor) {
+	client.compressor = c
+}
+
+// SetCodec sets the codec of cached values
+func (client *Client) SetCodec(c codec.Codec) {
+	client.codec = c
+}
+
+func (client *Client) key(key string) string {
+	return client.prefix + key
+}
+
+func (client *Client) indexKey() string {
+	return client.prefix + requestIndexName
+}
+
+// pattern returns SCAN pattern matching all keys in the namespace
+func (client *Client) pattern() string {
+	var b strings.Builder
+	for _, r := range client.prefix {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteRune('*')
+	return b.String()
+}
+
+// scan calls fn for every batch of value keys in the namespace
+func (client *Client) scan(fn func(keys []string) error) error {
+	// every cluster master holds its own keyspace
+	if cluster, ok := client.UniversalClient.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(client.Context(), func(ctx context.Context, node *redis.Client) error {
+			return client.scanNode(ctx, node, fn)
+		})
+	}
+	return client.scanNode(client.Context(), client.UniversalClient, fn)
+}
+
+func (client *Client) scanNode(ctx context.Context, node redis.Cmdable, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, client.pattern(), scanCount).Result()
+		if err != nil {
+			return err
+		}
+		keys = client.valueKeys(keys)
+		if len(keys) > 0 {
+			client.scanLock.Lock()
+			err := fn(keys)
+			client.scanLock.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// valueKeys filters out the request index
+func (client *Client) valueKeys(keys []string) []string {
+	for idx, key := range keys {
+		if key == client.indexKey() {
+			return append(keys[:idx:idx], keys[idx+1:]...)
+		}
+	}
+	return keys
+}
+
+// ensureIndex indexes values stored before the request index was introduced.
+// Missing index means there are no indexed values yet
+func (client *Client) ensureIndex() error {
+	exists, err := client.UniversalClient.Exists(client.Context(), client.indexKey()).Result()
+	if err != nil || exists > 0 {
+		return err
+	}
+	indexed := 0
+	err = client.scan(func(keys []string) error {
+		data, err := client.getMany(keys)
+		if err != nil {
+			return err
+		}
+		pipe := client.UniversalClient.Pipeline()
+		for key, value := range data {
+			item := cacheValue{}
+			if err := decodeValue(value, &item); err != nil {
+				logger.Log.Errorf("Cannot decode cache value %q: %v", key, err)
+				continue
+			}
+			index, err := encodeIndex(client.codec, item.index())
+			if err != nil {
+				return err
+			}
+			pipe.HSet(client.Context(), client.indexKey(), strings.TrimPrefix(key, client.prefix), index)
+			indexed++
+		}
+		_, err = pipe.Exec(client.Context())
+		return err
+	})
 	if err != nil {
+		return err
+	}
+	if indexed > 0 {
+		logger.Log.Infof("Indexed %d cached redis requests", indexed)
+	}
+	return nil
+}
+
+// getMany returns values of the keys. Keys might belong to different cluster slots, so no MGET
+func (client *Client) getMany(keys []string) (map[string][]byte, error) {
+	pipe := client.UniversalClient.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for idx, key := range keys {
+		cmds[idx] = pipe.Get(client.Context(), key)
+	}
+	if _, err := pipe.Exec(client.Context()); err != nil && !errors.Is(err, redis.Nil) {
 		return nil, err
 	}
-	opts.PoolSize = config.PoolSize
-	opts.TLSConfig = tlsConfig
+	res := make(map[string][]byte, len(keys))
+	for idx, cmd := range cmds {
+		data, err := cmd.Bytes()
+		if err != nil {
+			// key has expired after scanning
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			return nil, err
+		}
+		res[keys[idx]] = data
+	}
+	return res, nil
+}
 
-	client := redis.NewClient(opts).WithContext(ctx)
-	if _, err = client.Ping(client.Context()).Result(); err != nil {
-		return nil, fmt.Errorf("cannot initialize redis client: %w", err)
+func (client *Client) deleteMany(keys []string) error {
+	pipe := client.UniversalClient.Pipeline()
+	for _, key := range keys {
+		pipe.Del(client.Context(), key)
+	}
+	_, err := pipe.Exec(client.Context())
+	return err
+}
+
+// migrateLegacyHash moves values from the legacy hash to per-key storage
+func (client *Client) migrateLegacyHash() error {
+	var cursor uint64
+	migrated := 0
+	now := time.Now()
+	for {
+		fields, next, err := client.UniversalClient.HScan(client.Context(), legacyHashMapName, cursor, "", scanCount).Result()
+		if err != nil {
+			return err
+		}
+		pipe := client.UniversalClient.Pipeline()
+		// HSCAN returns field and value pairs
+		for idx := 0; idx+1 < len(fields); idx += 2 {
+			item := cacheValue{}
+			if err := bson.Unmarshal([]byte(fields[idx+1]), &item); err != nil {
+				logger.Log.Errorf("Cannot decode legacy cache value %q: %v", fields[idx], err)
+				continue
+			}
+			if item.isExpired(now) {
+				continue
+			}
+			var expiration time.Duration
+			if item.KeepUntil != 0 {
+				expiration = time.Duration(item.KeepUntil - now.UnixNano())
+			}
+			index, err := encodeIndex(client.codec, item.index())
+			if err != nil {
+				return err
+			}
+			pipe.Set(client.Context(), client.key(fields[idx]), fields[idx+1], expiration)
+			pipe.HSet(client.Context(), client.indexKey(), fields[idx], index)
+			migrated++
+		}
+		if _, err := pipe.Exec(client.Context()); err != nil {
+			return err
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
 	}
-	return &Client{
-		Client: client,
-	}, nil
+	if err := client.UniversalClient.Del(client.Context(), legacyHashMapName).Err(); err != nil {
+		return err
+	}
+	if migrated > 0 {
+		logger.Log.Infof("Migrated %d values from legacy redis hash %q", migrated, legacyHashMapName)
+	}
+	return nil
 }
 
 func (client *Client) Get(key string) (requests.RPCResponse, error) {
+	val, ok, err := client.get(key)
+	if err != nil || !ok || !val.isFresh(time.Now()) {
+		return requests.RPCResponse{}, err
+	}
+	return val.Response, nil
+}
+
+func (client *Client) GetStale(key string) (requests.RPCResponse, bool, error) {
+	val, ok, err := client.get(key)
+	if err != nil || !ok {
+		return requests.RPCResponse{}, false, err
+	}
+	return val.Response, !val.isFresh(time.Now()), nil
+}
+
+// get returns the cache value. false means there is no value
+func (client *Client) get(key string) (cacheValue, bool, error) {
 	val := cacheValue{}
-	data, err := client.Client.HGet(client.Context(), hashMapName, key).Bytes()
+	data, err := client.UniversalClient.Get(client.Context(), client.key(key)).Bytes()
 	if err != nil {
-		return val.Response, err
+		if errors.Is(err, redis.Nil) {
+			return val, false, nil
+		}
+		return val, false, err
 	}
-	if err := bson.Unmarshal(data, &val); err != nil {
-		return val.Response, err
+	if err := decodeValue(data, &val); err != nil {
+		return val, false, err
 	}
-	return val.Response, nil
+	return val, true, nil
 }
 
-func (client *Client) Set(key string, request requests.RPCRequest, response requests.RPCResponse) error {
-	item := cacheValue{
-		Request:  request,
-		Response: response,
+func (client *Client) Set(key string, request requests.RPCRequest, response requests.RPCResponse, ttl, maxStale time.Duration) error {
+	item := newCacheValue(request, response, ttl, maxStale)
+	data, err := encodeValue(client.codec, client.compressor, item)
+	if err != nil {
+		return err
 	}
-	data, err := bson.Marshal(item)
+	index, err := encodeIndex(client.codec, item.index())
 	if err != nil {
 		return err
 	}
-	return client.Client.HSet(client.Context(), hashMapName, key, data).Err()
+	// the value and the index might belong to different cluster slots, so no transaction
+	pipe := client.UniversalClient.Pipeline()
+	pipe.Set(client.Context(), client.key(key), data, retention(ttl, maxStale))
+	pipe.HSet(client.Context(), client.indexKey(), key, index)
+	_, err = pipe.Exec(client.Context())
+	return err
+}
+
+func (client *Client) Delete(key string) error {
+	pipe := client.UniversalClient.Pipeline()
+	pipe.Del(client.Context(), client.key(key))
+	pipe.HDel(client.Context(), client.indexKey(), key)
+	_, err := pipe.Exec(client.Context())
+	return err
 }
 
-func (client *Client) Requests() ([]requests.RPCRequest, error) {
-	data, err := client.Client.HVals(client.Context(), hashMapName).Result()
+// Entries reads the request index and sizes of values, values are not read
+func (client *Client) Entries() ([]Entry, error) {
+	var res []Entry
+	err := client.scanIndex(func(key string, item indexValue, size int) error {
+		res = append(res, newEntry(key, item.cacheValue(), size))
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	res := make([]requests.RPCRequest, len(data))
-	for idx, value := range data {
-		item := cacheValue{}
-		if err := bson.Unmarshal([]byte(value), &item); err != nil {
-			return nil, err
+	return res, nil
+}
+
+func (client *Client) GetEntry(key string) (Entry, bool, error) {
+	data, err := client.UniversalClient.Get(client.Context(), client.key(key)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return Entry{}, false, nil
+		}
+		return Entry{}, false, err
+	}
+	val := cacheValue{}
+	if err := decodeValue(data, &val); err != nil {
+		return Entry{}, false, err
+	}
+	return newEntry(key, val, len(data)), true, nil
+}
+
+// ScanRequests reads the request index only
+func (client *Client) ScanRequests(fn func(key string, request requests.RPCRequest) error) error {
+	return client.scanIndex(func(key string, item indexValue, _ int) error {
+		return fn(key, item.Request)
+	})
+}
+
+// scanIndex calls fn for every indexed value with its size. Index entries of expired values and values which
+// no longer exist, e.g. evicted by redis, are removed while scanning
+func (client *Client) scanIndex(fn func(key string, item indexValue, size int) error) error {
+	var cursor uint64
+	now := time.Now()
+	for {
+		fields, next, err := client.UniversalClient.HScan(client.Context(), client.indexKey(), cursor, "", scanCount).Result()
+		if err != nil {
+			return err
+		}
+		var stale []string
+		var keys []string
+		var items []indexValue
+		// HSCAN returns field and value pairs
+		for idx := 0; idx+1 < len(fields); idx += 2 {
+			item := indexValue{}
+			if err := decodeIndex([]byte(fields[idx+1]), &item); err != nil {
+				return err
+			}
+			if item.isExpired(now) {
+				stale = append(stale, fields[idx])
+				continue
+			}
+			keys = append(keys, fields[idx])
+			items = append(items, item)
+		}
+		sizes, err := client.sizeMany(keys)
+		if err != nil {
+			return err
 		}
-		res[idx] = item.Request
+		for idx, key := range keys {
+			if sizes[idx] == 0 {
+				stale = append(stale, key)
+				continue
+			}
+			if err := fn(key, items[idx], sizes[idx]); err != nil {
+				return err
+			}
+		}
+		if len(stale) > 0 {
+			if err := client.UniversalClient.HDel(client.Context(), client.indexKey(), stale...).Err(); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// sizeMany returns sizes of values of the keys. Zero means the value does not exist, encoded values are never empty.
+// Keys are checked one by one within the pipeline, since they might belong to different cluster slots
+func (client *Client) sizeMany(keys []string) ([]int, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	pipe := client.UniversalClient.Pipeline()
+	cmds := make([]*redis.IntCmd, len(keys))
+	for idx, key := range keys {
+		cmds[idx] = pipe.StrLen(client.Context(), client.key(key))
+	}
+	if _, err := pipe.Exec(client.Context()); err != nil {
+		return nil, err
+	}
+	res := make([]int, len(keys))
+	for idx, cmd := range cmds {
+		res[idx] = int(cmd.Val())
 	}
 	return res, nil
 }
 
 // Close closes redis client
 func (client *Client) Close() error {
-	if err := client.Client.Close(); err != nil {
+	if err := client.UniversalClient.Close(); err != nil {
 		return fmt.Errorf("cannot close redis client %w", err)
 	}
 	return nil
 }
 
-// Clean cleans all cache
+// Clean removes all cached values in the namespace
 func (client *Client) Clean() error {
-	if err := client.Client.FlushAll(client.Context()).Err(); err != nil {
-		return fmt.Errorf("cannot flush redis database %w", err)
+	err := client.scan(client.deleteMany)
+	if err == nil {
+		err = client.UniversalClient.Del(client.Context(), client.indexKey()).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("cannot clean redis cache %w", err)
 	}
 	return nil
 }
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redis"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	require.NoError(t, err)
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	tlsConfig, err := newTLSConfig(config.RedisTLSSettings{}, false, "redis.local")
+	require.NoError(t, err)
+	require.Nil(t, tlsConfig)
+
+	tlsConfig, err = newTLSConfig(config.RedisTLSSettings{}, true, "redis.local")
+	require.NoError(t, err)
+	require.Equal(t, "redis.local", tlsConfig.ServerName)
+	require.False(t, tlsConfig.InsecureSkipVerify)
+
+	dir, err := ioutil.TempDir("", "redis-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCertificate(t, dir)
+
+	tlsConfig, err = newTLSConfig(config.RedisTLSSettings{
+		Enabled:    true,
+		CAFile:     certFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		ServerName: "override.local",
+	}, false, "redis.local")
+	require.NoError(t, err)
+	require.Equal(t, "override.local", tlsConfig.ServerName)
+	require.NotNil(t, tlsConfig.RootCAs)
+	require.Len(t, tlsConfig.Certificates, 1)
+
+	_, err = newTLSConfig(config.RedisTLSSettings{Enabled: true, CAFile: keyFile}, false, "")
+	require.Error(t, err)
+}
+
+func TestRedisLegacyHashMigration(t *testing.T) {
+	testhelpers.SkipWithoutRedis(t)
+	ctx := context.Background()
+	settings := config.RedisCacheSettings{URI: testhelpers.RedisURI, Prefix: "migration:"}
+	legacy, err := NewRedisClient(ctx, settings)
+	require.NoError(t, err)
+	defer func() {
+		_ = legacy.Close()
+	}()
+
+	request := requests.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "test", Params: []interface{}{"1"}}
+	response := requests.RPCResponse{JSONRPC: "2.0", ID: 1, Result: "result"}
+	data, err := bson.Marshal(bson.M{"request": request, "response": response})
+	require.NoError(t, err)
+	require.NoError(t, legacy.HSet(ctx, "filecoin", "key", data).Err())
+
+	settings.MigrateLegacyHash = true
+	client, err := NewRedisClient(ctx, settings)
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Clean()
+		_ = client.Close()
+	}()
+
+	value, err := client.Get("key")
+	require.NoError(t, err)
+	require.Equal(t, response.Result, value.Result)
+	exists, err := client.Exists(ctx, "filecoin").Result()
+	require.NoError(t, err)
+	require.Equal(t, int64(0), exists)
+	reqs, err := Requests(client)
+	require.NoError(t, err)
+	require.Len(t, reqs, 1)
+	require.Equal(t, request.Method, reqs[0].Method)
+}
+
+func TestRedisRequestIndex(t *testing.T) {
+	testhelpers.SkipWithoutRedis(t)
+	ctx := context.Background()
+	settings := config.RedisCacheSettings{URI: testhelpers.RedisURI, Prefix: "index:"}
+	client, err := NewRedisClient(ctx, settings)
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Clean()
+		_ = client.Close()
+	}()
+
+	request := requests.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "test", Params: []interface{}{"1"}}
+	response := requests.RPCResponse{JSONRPC: "2.0", ID: 1, Result: "result"}
+	require.NoError(t, client.Set("key", request, response, 0, 0))
+	require.NoError(t, client.Set("other", request, response, 0, 0))
+	require.NoError(t, client.Delete("other"))
+	entries, err := client.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "key", entries[0].Key)
+	require.Equal(t, request.Method, entries[0].Request.Method)
+	require.NotZero(t, entries[0].Size)
+
+	// values stored before the index are indexed on startup
+	require.NoError(t, client.Del(ctx, "index:__requests__").Err())
+	indexed, err := NewRedisClient(ctx, settings)
+	require.NoError(t, err)
+	defer func() {
+		_ = indexed.Close()
+	}()
+	reqs, err := Requests(indexed)
+	require.NoError(t, err)
+	require.Len(t, reqs, 1)
+	require.Equal(t, request.Method, reqs[0].Method)
+	require.Equal(t, request.Params, reqs[0].Params)
+
+	// index entries of values evicted by redis are removed while scanning
+	require.NoError(t, indexed.Set("evicted", request, response, 0, 0))
+	require.NoError(t, indexed.Del(ctx, "index:evicted").Err())
+	reqs, err = Requests(indexed)
+	require.NoError(t, err)
+	require.Len(t, reqs, 1)
+	exists, err := indexed.HExists(ctx, "index:__requests__", "evicted").Result()
+	require.NoError(t, err)
+	require.False(t, exists)
+}
@@ -0,0 +1,282 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+)
+
+const snapshotVersion byte = 1
+
+var snapshotMagic = []byte("FCSNAP")
+
+type snapshotEntry struct {
+	key   string
+	value memoryValue
+	// unix nanoseconds. zero means no expiration
+	expires int64
+}
+
+// memoryStorage is memory cache which entries can be saved to and restored from snapshots
+type memoryStorage interface {
+	Cache
+	// entries returns unexpired entries
+	entries() []snapshotEntry
+	// load stores entries in the cache
+	load(entries []snapshotEntry)
+}
+
+// SnapshotCache saves memory cache entries to the snapshot file on Close and restores them on startup
+type SnapshotCache struct {
+	memoryStorage
+	path   string
+	maxAge time.Duration
+	lock   sync.Mutex
+	// closed snapshot is not saved anymore, so the memory cache emptied by Close does not overwrite it
+	closed bool
+}
+
+// NewSnapshotCache initializes snapshot cache. Zero maxAge means snapshots of any age are restored
+func NewSnapshotCache(storage memoryStorage, path string, maxAge time.Duration) *SnapshotCache {
+	return &SnapshotCache{
+		memoryStorage: storage,
+		path:          path,
+		maxAge:        maxAge,
+	}
+}
+
+// Save writes all unexpired entries to the snapshot file. Closed cache is not saved
+func (s *SnapshotCache) Save() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil
+	}
+	return s.save()
+}
+
+func (s *SnapshotCache) save() error {
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if err := writeSnapshot(tmp, time.Now(), s.memoryStorage.entries()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Load restores entries from the snapshot file. Missing file is not an error
+func (s *SnapshotCache) Load() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+	created, entries, err := readSnapshot(file, time.Now())
+	if err != nil {
+		return fmt.Errorf("corrupt cache snapshot %s: %w", s.path, err)
+	}
+	if age := time.Since(created); s.maxAge > 0 && age > s.maxAge {
+		return fmt.Errorf("cache snapshot %s is stale: %s old", s.path, age.Round(time.Second))
+	}
+	s.memoryStorage.load(entries)
+	logger.Log.Infof("Restored %d values from cache snapshot %s", len(entries), s.path)
+	return nil
+}
+
+// Start periodically saves the snapshot until the context is done
+func (s *SnapshotCache) Start(ctx context.Context, period int) {
+	ticker := time.NewTicker(time.Second * time.Duration(period))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Save(); err != nil {
+				logger.Log.Errorf("Cannot save cache snapshot: %v", err)
+			}
+		}
+	}
+}
+
+// Close saves the snapshot and closes the memory cache. Subsequent calls do nothing
+func (s *SnapshotCache) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	err := s.save()
+	s.lock.Unlock()
+	if err != nil {
+		logger.Log.Errorf("Cannot save cache snapshot: %v", err)
+	}
+	return s.memoryStorage.Close()
+}
+
+// NewSnapshotCacheFromConfig wraps memory cache with snapshots and restores the last snapshot.
+// Stale and corrupt snapshots are skipped
+func NewSnapshotCacheFromConfig(ctx context.Context, storage memoryStorage, settings config.MemorySnapshotSettings) *SnapshotCache {
+	snapshot := NewSnapshotCache(storage, settings.Path, time.Duration(settings.MaxAge)*time.Second)
+	if err := snapshot.Load(); err != nil {
+		logger.Log.Warnf("Skipping cache snapshot: %v", err)
+	}
+	if settings.Interval > 0 {
+		go snapshot.Start(ctx, settings.Interval)
+	}
+	return snapshot
+}
+
+// writeSnapshot writes the header, the entries and CRC32 checksum of them
+func writeSnapshot(w io.Writer, created time.Time, entries []snapshotEntry) error {
+	buf := bufio.NewWriter(w)
+	checksum := crc32.NewIEEE()
+	out := io.MultiWriter(buf, checksum)
+	if _, err := out.Write(snapshotMagic); err != nil {
+		return err
+	}
+	if _, err := out.Write([]byte{snapshotVersion}); err != nil {
+		return err
+	}
+	if err := binary.Write(out, binary.BigEndian, created.UnixNano()); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		data := entry.value.data
+		if data == nil {
+			var err error
+			if data, err = encodeValue(nil, nil, entry.value.cacheValue); err != nil {
+				return err
+			}
+		}
+		if err := writeSnapshotBytes(out, []byte(entry.key)); err != nil {
+			return err
+		}
+		if err := binary.Write(out, binary.BigEndian, entry.expires); err != nil {
+			return err
+		}
+		if err := writeSnapshotBytes(out, data); err != nil {
+			return err
+		}
+	}
+	// zero length key terminates entries
+	if err := binary.Write(out, binary.BigEndian, uint32(0)); err != nil {
+		return err
+	}
+	if err := binary.Write(buf, binary.BigEndian, checksum.Sum32()); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+func writeSnapshotBytes(w io.Writer, data []byte) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// readSnapshot reads snapshot entries which are not expired at now
+func readSnapshot(r io.Reader, now time.Time) (time.Time, []snapshotEntry, error) {
+	checksum := crc32.NewIEEE()
+	in := io.TeeReader(bufio.NewReader(r), checksum)
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return time.Time{}, nil, err
+	}
+	if string(header[:len(snapshotMagic)]) != string(snapshotMagic) {
+		return time.Time{}, nil, fmt.Errorf("not a cache snapshot")
+	}
+	if version := header[len(snapshotMagic)]; version != snapshotVersion {
+		return time.Time{}, nil, fmt.Errorf("unknown cache snapshot version: %d", version)
+	}
+	var created int64
+	if err := binary.Read(in, binary.BigEndian, &created); err != nil {
+		return time.Time{}, nil, err
+	}
+
+	var entries []snapshotEntry
+	for {
+		key, err := readSnapshotBytes(in)
+		if err != nil {
+			return time.Time{}, nil, err
+		}
+		if len(key) == 0 {
+			break
+		}
+		var expires int64
+		if err := binary.Read(in, binary.BigEndian, &expires); err != nil {
+			return time.Time{}, nil, err
+		}
+		data, err := readSnapshotBytes(in)
+		if err != nil {
+			return time.Time{}, nil, err
+		}
+		if expires != 0 && now.UnixNano() > expires {
+			continue
+		}
+		value := cacheValue{}
+		if err := decodeValue(data, &value); err != nil {
+			return time.Time{}, nil, err
+		}
+		entry := snapshotEntry{key: string(key), value: memoryValue{cacheValue: value}, expires: expires}
+		if isCompressed(data) {
+			entry.value.Response = requests.RPCResponse{}
+			entry.value.data = data
+		}
+		entries = append(entries, entry)
+	}
+
+	expected := checksum.Sum32()
+	var actual uint32
+	if err := binary.Read(in, binary.BigEndian, &actual); err != nil {
+		return time.Time{}, nil, err
+	}
+	if actual != expected {
+		return time.Time{}, nil, fmt.Errorf("checksum mismatch")
+	}
+	return time.Unix(0, created), entries, nil
+}
+
+func readSnapshotBytes(r io.Reader) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	// corrupt size fails on EOF instead of allocating it upfront
+	data := bytes.NewBuffer(nil)
+	if _, err := io.CopyN(data, r, int64(size)); err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func snapshotTestPath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return filepath.Join(dir, "cache.snapshot")
+}
+
+func TestSnapshotCacheRestore(t *testing.T) {
+	path := snapshotTestPath(t)
+	compressed := compressionTestValue("compressed")
+	reqs, resps := lruTestValues(2)
+	for _, newStorage := range []func() memoryStorage{
+		func() memoryStorage { return NewMemoryCacheDefault() },
+		func() memoryStorage { return NewLRUCache(0, 10, 0) },
+	} {
+		storage := newStorage()
+		storage.(interface{ SetCompressor(*Compressor) }).SetCompressor(
+			NewCompressor(config.GzipCompression, 6, 0, "compressed"),
+		)
+		snapshot := NewSnapshotCache(storage, path, time.Minute)
+		require.NoError(t, snapshot.Set("0", reqs[0], resps[0], 0, 0))
+		require.NoError(t, snapshot.Set("1", reqs[1], resps[1], time.Millisecond, 0))
+		require.NoError(t, snapshot.Set("2", compressed.Request, compressed.Response, 0, 0))
+		time.Sleep(2 * time.Millisecond)
+		require.NoError(t, snapshot.Close())
+		// the second close must not overwrite the snapshot with the closed cache
+		require.NoError(t, snapshot.Close())
+		require.NoError(t, snapshot.Save())
+
+		restored := NewSnapshotCache(newStorage(), path, time.Minute)
+		require.NoError(t, restored.Load())
+		value, err := restored.Get("0")
+		require.NoError(t, err)
+		require.Equal(t, resps[0].Result, value.Result)
+		value, err = restored.Get("1")
+		require.NoError(t, err)
+		require.True(t, value.IsEmpty())
+		value, err = restored.Get("2")
+		require.NoError(t, err)
+		require.Equal(t, compressed.Response.Result, value.Result)
+		cachedReqs, err := Requests(restored)
+		require.NoError(t, err)
+		require.Contains(t, cachedReqs, reqs[0])
+	}
+}
+
+func TestSnapshotCacheSkipsStaleAndCorrupt(t *testing.T) {
+	path := snapshotTestPath(t)
+	reqs, resps := lruTestValues(1)
+	snapshot := NewSnapshotCache(NewLRUCache(0, 10, 0), path, 0)
+	require.NoError(t, snapshot.Set("0", reqs[0], resps[0], 0, 0))
+	require.NoError(t, snapshot.Save())
+
+	stale := NewSnapshotCache(NewLRUCache(0, 10, 0), path, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	require.Error(t, stale.Load())
+	value, err := stale.Get("0")
+	require.NoError(t, err)
+	require.True(t, value.IsEmpty())
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)/2] ^= 0xff
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+	corrupt := NewSnapshotCache(NewLRUCache(0, 10, 0), path, 0)
+	require.Error(t, corrupt.Load())
+	value, err = corrupt.Get("0")
+	require.NoError(t, err)
+	require.True(t, value.IsEmpty())
+
+	missing := NewSnapshotCache(NewLRUCache(0, 10, 0), path+".missing", 0)
+	require.NoError(t, missing.Load())
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+)
+
+// MethodStats describes cached values of the method
+type MethodStats struct {
+	Entries int `json:"entries"`
+	Bytes   int `json:"bytes"`
+	Stale   int `json:"stale"`
+}
+
+// Stats describes cached values
+type Stats struct {
+	Entries int                    `json:"entries"`
+	Bytes   int                    `json:"bytes"`
+	Methods map[string]MethodStats `json:"methods"`
+}
+
+// NewStats counts entries and their sizes per method
+func NewStats(entries []Entry, now time.Time) Stats {
+	stats := Stats{Methods: make(map[string]MethodStats)}
+	for _, entry := range entries {
+		method := stats.Methods[entry.Request.Method]
+		method.Entries++
+		method.Bytes += entry.Size
+		if entry.IsStale(now) {
+			method.Stale++
+		}
+		stats.Methods[entry.Request.Method] = method
+		stats.Entries++
+		stats.Bytes += entry.Size
+	}
+	return stats
+}
+
+// PurgeMatching deletes cached values of methods the function reports true for and returns their number.
+// Requests are read from the index, so responses are not read
+func PurgeMatching(c Cache, match func(method string) bool) (int, error) {
+	// keys are collected first, since storages are not modified while scanning
+	var keys []string
+	err := c.ScanRequests(func(key string, req requests.RPCRequest) error {
+		if match(req.Method) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for idx, key := range keys {
+		if err := c.Delete(key); err != nil {
+			return idx, err
+		}
+	}
+	return len(keys), nil
+}
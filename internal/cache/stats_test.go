@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsAndPurge(t *testing.T) {
+	cache := NewLRUCache(0, 0, 0)
+	reqs, resps := lruTestValues(3)
+	reqs[2].Method = "other"
+	require.NoError(t, cache.Set("0", reqs[0], resps[0], time.Minute, time.Hour))
+	require.NoError(t, cache.Set("1", reqs[1], resps[1], 0, 0))
+	require.NoError(t, cache.Set("2", reqs[2], resps[2], 0, 0))
+
+	entries, err := cache.Entries()
+	require.NoError(t, err)
+	stats := NewStats(entries, time.Now().Add(2*time.Minute))
+	require.Equal(t, 3, stats.Entries)
+	require.Equal(t, 2, stats.Methods["test"].Entries)
+	require.Equal(t, 1, stats.Methods["test"].Stale)
+	require.Equal(t, 1, stats.Methods["other"].Entries)
+	require.Equal(t, stats.Bytes, stats.Methods["test"].Bytes+stats.Methods["other"].Bytes)
+
+	purged, err := PurgeMatching(cache, func(method string) bool {
+		return method == "test"
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, purged)
+	entries, err = cache.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "2", entries[0].Key)
+}
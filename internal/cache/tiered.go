@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/codec"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+)
+
+// TieredCache serves values from memory cache in front of the shared redis cache.
+// Values are read through and written through the memory cache, which keeps them at most ttl
+type TieredCache struct {
+	memory *LRUCache
+	redis  *Client
+	ttl    time.Duration
+}
+
+// NewTieredCache initializes tiered cache
+func NewTieredCache(memory *LRUCache, redis *Client, ttl time.Duration) *TieredCache {
+	return &TieredCache{
+		memory: memory,
+		redis:  redis,
+		ttl:    ttl,
+	}
+}
+
+// NewTieredCacheFromConfig initializes tiered cache from config
+func NewTieredCacheFromConfig(ctx context.Context, settings config.CacheSettings) (*TieredCache, error) {
+	client, err := NewRedisClient(ctx, settings.Redis)
+	if err != nil {
+		return nil, err
+	}
+	return NewTieredCache(
+		NewLRUCache(settings.Tiered.MaxBytes, settings.Tiered.MaxEntries, 0),
+		client,
+		time.Duration(settings.Tiered.TTL)*time.Second,
+	), nil
+}
+
+// SetCompressor enables compression of cached values in both tiers
+func (t *TieredCache) SetCompressor(c *Compressor) {
+	t.memory.SetCompressor(c)
+	t.redis.SetCompressor(c)
+}
+
+// SetCodec sets the codec of cached values in both tiers
+func (t *TieredCache) SetCodec(c codec.Codec) {
+	t.memory.SetCodec(c)
+	t.redis.SetCodec(c)
+}
+
+// memoryTTL limits ttl of the value by the memory cache ttl
+func (t *TieredCache) memoryTTL(ttl time.Duration) time.Duration {
+	if ttl <= 0 || ttl > t.ttl {
+		return t.ttl
+	}
+	return ttl
+}
+
+func (t *TieredCache) setMemory(key string, request requests.RPCRequest, response requests.RPCResponse, ttl time.Duration) {
+	if err := t.memory.Set(key, request, response, t.memoryTTL(ttl), 0); err != nil {
+		logger.Log.Debugf("Cannot store value %q in memory cache: %v", key, err)
+	}
+}
+
+// Set ...
+func (t *TieredCache) Set(key string, request requests.RPCRequest, response requests.RPCResponse, ttl, maxStale time.Duration) error {
+	if err := t.redis.Set(key, request, response, ttl, maxStale); err != nil {
+		return err
+	}
+	t.setMemory(key, request, response, ttl)
+	return nil
+}
+
+// Get ...
+func (t *TieredCache) Get(key string) (requests.RPCResponse, error) {
+	response, stale, err := t.GetStale(key)
+	if err != nil || stale {
+		return requests.RPCResponse{}, err
+	}
+	return response, nil
+}
+
+// GetStale reads stale values from redis only. Memory cache keeps fresh values
+func (t *TieredCache) GetStale(key string) (requests.RPCResponse, bool, error) {
+	response, err := t.memory.Get(key)
+	if err != nil || !response.IsEmpty() {
+		return response, false, err
+	}
+	value, ok, err := t.redis.get(key)
+	if err != nil || !ok {
+		return requests.RPCResponse{}, false, err
+	}
+	if value.FreshUntil == 0 {
+		t.setMemory(key, value.Request, value.Response, 0)
+	} else if ttl := time.Until(time.Unix(0, value.FreshUntil)); ttl > 0 {
+		t.setMemory(key, value.Request, value.Response, ttl)
+	} else {
+		return value.Response, true, nil
+	}
+	return value.Response, false, nil
+}
+
+// Delete ...
+func (t *TieredCache) Delete(key string) error {
+	multiErr := &multierror.Error{}
+	multiErr = multierror.Append(multiErr, t.memory.Delete(key))
+	multiErr = multierror.Append(multiErr, t.redis.Delete(key))
+	return multiErr.ErrorOrNil()
+}
+
+// ScanRequests ...
+func (t *TieredCache) ScanRequests(fn func(key string, request requests.RPCRequest) error) error {
+	return t.redis.ScanRequests(fn)
+}
+
+// Entries ...
+func (t *TieredCache) Entries() ([]Entry, error) {
+	return t.redis.Entries()
+}
+
+// GetEntry ...
+func (t *TieredCache) GetEntry(key string) (Entry, bool, error) {
+	return t.redis.GetEntry(key)
+}
+
+// Close ...
+func (t *TieredCache) Close() error {
+	multiErr := &multierror.Error{}
+	multiErr = multierror.Append(multiErr, t.memory.Close())
+	multiErr = multierror.Append(multiErr, t.redis.Close())
+	return multiErr.ErrorOrNil()
+}
+
+// Clean ...
+func (t *TieredCache) Clean() error {
+	multiErr := &multierror.Error{}
+	multiErr = multierror.Append(multiErr, t.memory.Clean())
+	multiErr = multierror.Append(multiErr, t.redis.Clean())
+	return multiErr.ErrorOrNil()
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTieredCache(t *testing.T) {
+	testhelpers.SkipWithoutRedis(t)
+	ctx := context.Background()
+	settings := config.CacheSettings{
+		Redis:  config.RedisCacheSettings{URI: testhelpers.RedisURI, Prefix: "tiered:"},
+		Tiered: config.TieredCacheSettings{TTL: 60},
+	}
+	tiered, err := NewTieredCacheFromConfig(ctx, settings)
+	require.NoError(t, err)
+	shared, err := NewRedisClient(ctx, settings.Redis)
+	require.NoError(t, err)
+	defer func() {
+		_ = tiered.Clean()
+		_ = tiered.Close()
+		_ = shared.Close()
+	}()
+
+	request := requests.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "test", Params: []interface{}{"1"}}
+	response := requests.RPCResponse{JSONRPC: "2.0", ID: 1, Result: "result"}
+	require.NoError(t, tiered.Set("key", request, response, 0, 0))
+
+	// written through to redis
+	value, err := shared.Get("key")
+	require.NoError(t, err)
+	require.Equal(t, response.Result, value.Result)
+
+	// served from memory while redis no longer has the value
+	require.NoError(t, shared.Delete("key"))
+	value, err = tiered.Get("key")
+	require.NoError(t, err)
+	require.Equal(t, response.Result, value.Result)
+
+	// read through from redis
+	require.NoError(t, shared.Set("other", request, response, 0, 0))
+	value, err = tiered.Get("other")
+	require.NoError(t, err)
+	require.Equal(t, response.Result, value.Result)
+	require.NoError(t, shared.Delete("other"))
+	value, err = tiered.Get("other")
+	require.NoError(t, err)
+	require.Equal(t, response.Result, value.Result)
+
+	require.NoError(t, tiered.Delete("other"))
+	value, err = tiered.Get("other")
+	require.NoError(t, err)
+	require.True(t, value.IsEmpty())
+}
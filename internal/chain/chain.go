@@ -0,0 +1,61 @@
+package chain
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// HeightProvider provides the current chain head height
+type HeightProvider interface {
+	Height() int64
+}
+
+// Clock estimates the chain head height using the genesis timestamp and the block delay
+type Clock struct {
+	genesis    time.Time
+	blockDelay time.Duration
+}
+
+// NewClock initializes chain clock. genesis is unix timestamp, blockDelay is in seconds
+func NewClock(genesis int64, blockDelay int) *Clock {
+	return &Clock{
+		genesis:    time.Unix(genesis, 0),
+		blockDelay: time.Duration(blockDelay) * time.Second,
+	}
+}
+
+// Height returns the expected chain head height
+func (c *Clock) Height() int64 {
+	if c.blockDelay <= 0 {
+		return 0
+	}
+	elapsed := time.Since(c.genesis)
+	if elapsed < 0 {
+		return 0
+	}
+	return int64(elapsed / c.blockDelay)
+}
+
+// ParseEpoch converts JSON RPC parameter into chain epoch
+func ParseEpoch(param interface{}) (int64, error) {
+	switch value := param.(type) {
+	case float64:
+		return int64(value), nil
+	case int:
+		return int64(value), nil
+	case int64:
+		return value, nil
+	case json.Number:
+		return value.Int64()
+	case string:
+		epoch, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot parse epoch %q: %w", value, err)
+		}
+		return epoch, nil
+	default:
+		return 0, fmt.Errorf("invalid epoch parameter: %v", param)
+	}
+}
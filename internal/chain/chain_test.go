@@ -0,0 +1,28 @@
+package chain
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClockHeight(t *testing.T) {
+	genesis := time.Now().Add(-time.Hour).Unix()
+	clock := NewClock(genesis, 30)
+	require.InDelta(t, 120, clock.Height(), 1)
+	require.Equal(t, int64(0), NewClock(time.Now().Add(time.Hour).Unix(), 30).Height())
+}
+
+func TestParseEpoch(t *testing.T) {
+	for _, param := range []interface{}{float64(123), 123, int64(123), "123", json.Number("123")} {
+		epoch, err := ParseEpoch(param)
+		require.NoError(t, err)
+		require.Equal(t, int64(123), epoch)
+	}
+	_, err := ParseEpoch("latest")
+	require.Error(t, err)
+	_, err = ParseEpoch(nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,192 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/metrics"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+
+	"github.com/sirupsen/logrus"
+)
+
+const chainHeadMethod = "Filecoin.ChainHead"
+
+// Head represents the chain head tipset
+type Head struct {
+	Height    int64         `json:"height"`
+	Key       []interface{} `json:"key"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+// Equal reports whether both heads are the same tipset
+func (h Head) Equal(other Head) bool {
+	return h.Height == other.Height && reflect.DeepEqual(h.Key, other.Key)
+}
+
+// HeadProvider provides the current chain head
+type HeadProvider interface {
+	HeightProvider
+	// Head returns the last observed chain head. false means no head has been observed yet
+	Head() (Head, bool)
+}
+
+type tipSet struct {
+	Cids   []interface{} `json:"Cids"`
+	Blocks []struct {
+		Timestamp int64 `json:"Timestamp"`
+	} `json:"Blocks"`
+	Height int64 `json:"Height"`
+}
+
+// Tracker polls the upstream for the chain head
+type Tracker struct {
+	clock             *Clock
+	logger            *logrus.Entry
+	url               string
+	token             string
+	debugHTTPRequest  bool
+	debugHTTPResponse bool
+	lock              sync.RWMutex
+	head              *Head
+	subscribers       []func(Head)
+}
+
+// NewTracker initializes chain head tracker
+func NewTracker(
+	clock *Clock,
+	logger *logrus.Entry,
+	url, token string,
+	debugHTTPRequest bool,
+	debugHTTPResponse bool,
+) *Tracker {
+	return &Tracker{
+		clock:             clock,
+		logger:            logger,
+		url:               url,
+		token:             token,
+		debugHTTPRequest:  debugHTTPRequest,
+		debugHTTPResponse: debugHTTPResponse,
+	}
+}
+
+// FromConfig initializes chain head tracker from config
+func FromConfig(conf *config.Config, logger *logrus.Entry) (*Tracker, error) {
+	token, err := auth.NewJWT(conf.JWT(), conf.JWTAlgorithm, conf.JWTPermissions)
+	if err != nil {
+		return nil, err
+	}
+	return NewTracker(
+		NewClock(conf.Chain.GenesisTimestamp, conf.Chain.BlockDelay),
+		logger,
+		conf.ProxyURL,
+		string(token),
+		conf.DebugHTTPRequest,
+		conf.DebugHTTPResponse,
+	), nil
+}
+
+// Height returns the tracked chain head height. Estimated height is used until the head is observed
+func (t *Tracker) Height() int64 {
+	if head, ok := t.Head(); ok {
+		return head.Height
+	}
+	return t.clock.Height()
+}
+
+// Head returns the last observed chain head
+func (t *Tracker) Head() (Head, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if t.head == nil {
+		return Head{}, false
+	}
+	return *t.head, true
+}
+
+// OnNewHead registers callback called on every newly observed chain head. Should be called before Start
+func (t *Tracker) OnNewHead(fn func(Head)) {
+	t.subscribers = append(t.subscribers, fn)
+}
+
+// Start polls the chain head until the context is done
+func (t *Tracker) Start(ctx context.Context, period int) {
+	defer t.logger.Info("Exiting chain head tracker...")
+
+	ticker := time.NewTicker(time.Second * time.Duration(period))
+	defer ticker.Stop()
+
+	if err := t.update(); err != nil {
+		t.logger.Errorf("cannot update chain head: %v", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.update(); err != nil {
+				t.logger.Errorf("cannot update chain head: %v", err)
+			}
+		}
+	}
+}
+
+func (t *Tracker) update() error {
+	head, err := t.fetch()
+	if err != nil {
+		metrics.SetChainHeadErrorsCounter()
+		return err
+	}
+	t.lock.Lock()
+	changed := t.head == nil || !t.head.Equal(head)
+	t.head = &head
+	t.lock.Unlock()
+	metrics.SetChainHead(head.Height, head.Timestamp.Unix())
+	if changed {
+		for _, fn := range t.subscribers {
+			fn(head)
+		}
+	}
+	return nil
+}
+
+func (t *Tracker) fetch() (Head, error) {
+	reqs := requests.RPCRequests{{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  chainHeadMethod,
+	}}
+	responses, _, err := requests.Request(t.url, t.token, t.logger, t.debugHTTPRequest, t.debugHTTPResponse, reqs)
+	if err != nil {
+		return Head{}, err
+	}
+	if len(responses) != 1 {
+		return Head{}, fmt.Errorf("unexpected number of chain head responses: %d", len(responses))
+	}
+	if responses[0].Error != nil {
+		return Head{}, responses[0].Error
+	}
+	data, err := json.Marshal(responses[0].Result)
+	if err != nil {
+		return Head{}, err
+	}
+	ts := tipSet{}
+	if err := json.Unmarshal(data, &ts); err != nil {
+		return Head{}, fmt.Errorf("cannot parse chain head: %w", err)
+	}
+	head := Head{
+		Height: ts.Height,
+		Key:    ts.Cids,
+	}
+	if len(ts.Blocks) > 0 {
+		head.Timestamp = time.Unix(ts.Blocks[0].Timestamp, 0).UTC()
+	}
+	return head, nil
+}
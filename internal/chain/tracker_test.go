@@ -0,0 +1,68 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracker(t *testing.T) {
+	log := logger.InitDefaultLogger()
+	timestamp := time.Now().Add(-time.Minute).Unix()
+	key := []interface{}{map[string]interface{}{"/": "bafy2bzacea"}}
+	response := requests.RPCResponse{
+		JSONRPC: "2.0",
+		ID:      1,
+		Result: map[string]interface{}{
+			"Cids":   key,
+			"Blocks": []interface{}{map[string]interface{}{"Timestamp": timestamp}},
+			"Height": 12345,
+		},
+	}
+	responseJSON, err := json.Marshal(response)
+	require.NoError(t, err)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err := fmt.Fprint(w, string(responseJSON))
+		if err != nil {
+			log.Error(err)
+		}
+	}))
+	defer backend.Close()
+
+	clock := NewClock(time.Now().Add(-time.Hour).Unix(), 30)
+	tracker := NewTracker(clock, log, backend.URL, "token", false, false)
+	_, ok := tracker.Head()
+	require.False(t, ok)
+	require.Equal(t, clock.Height(), tracker.Height())
+
+	var heads []Head
+	tracker.OnNewHead(func(head Head) {
+		heads = append(heads, head)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tracker.Start(ctx, 1)
+	// the same head does not notify subscribers
+	require.NoError(t, tracker.update())
+	require.Len(t, heads, 1)
+
+	head, ok := tracker.Head()
+	require.True(t, ok)
+	require.Equal(t, int64(12345), head.Height)
+	require.Equal(t, key, head.Key)
+	require.Equal(t, timestamp, head.Timestamp.Unix())
+	require.Equal(t, int64(12345), tracker.Height())
+}
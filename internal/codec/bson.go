@@ -0,0 +1,31 @@
+package codec
+
+import (
+	"fmt"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// BSON decodes values encoded as BSON before codecs were introduced. New values are never encoded as BSON,
+// since it does not round-trip big numbers
+type BSON struct{}
+
+// ID ...
+func (BSON) ID() byte {
+	return BSONID
+}
+
+// Name ...
+func (BSON) Name() string {
+	return "bson"
+}
+
+// Marshal fails, BSON is decoded only
+func (BSON) Marshal(interface{}) ([]byte, error) {
+	return nil, fmt.Errorf("bson codec is decode only")
+}
+
+// Unmarshal ...
+func (BSON) Unmarshal(data []byte, v interface{}) error {
+	return bson.Unmarshal(data, v)
+}
@@ -0,0 +1,49 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+var (
+	// sorted map keys make encoded values deterministic
+	cborEncMode, _ = cbor.EncOptions{Sort: cbor.SortCanonical}.EncMode()
+	// maps are decoded as JSON objects are
+	cborDecMode, _ = cbor.DecOptions{
+		DefaultMapType:  reflect.TypeOf(map[string]interface{}(nil)),
+		MaxNestedLevels: 256,
+	}.DecMode()
+)
+
+// CBOR encodes values as CBOR, RFC 8949. Struct fields are named by their json tags
+type CBOR struct{}
+
+// ID ...
+func (CBOR) ID() byte {
+	return CBORID
+}
+
+// Name ...
+func (CBOR) Name() string {
+	return "cbor"
+}
+
+// Marshal ...
+func (CBOR) Marshal(v interface{}) ([]byte, error) {
+	return cborEncMode.Marshal(v)
+}
+
+// Unmarshal ...
+func (CBOR) Unmarshal(data []byte, v interface{}) error {
+	decoder := cborDecMode.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid cbor value: %w", err)
+	}
+	if decoder.NumBytesRead() != len(data) {
+		return fmt.Errorf("invalid cbor value: trailing data")
+	}
+	return nil
+}
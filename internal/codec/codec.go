@@ -0,0 +1,90 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Codec identifiers are stored within encoded values and must never change
+const (
+	BSONID byte = iota
+	JSONID
+	CBORID
+	MessagePackID
+)
+
+// maxExactInteger is the largest integer every smaller integer of which is exactly represented by float64
+const maxExactInteger = 1 << 53
+
+// Codec encodes and decodes values. Decoded numbers differ by codec, NarrowNumbers makes them the same
+// as numbers parsed by encoding/json
+type Codec interface {
+	// ID identifies the codec within encoded values
+	ID() byte
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// codecs encode new values. BSON values are decoded only
+var codecs = []Codec{JSON{}, CBOR{}, MessagePack{}}
+
+// ByID returns the codec with the identifier, including the BSON codec of legacy values
+func ByID(id byte) (Codec, error) {
+	if id == BSONID {
+		return BSON{}, nil
+	}
+	for _, c := range codecs {
+		if c.ID() == id {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown codec: %d", id)
+}
+
+// ByName returns the codec of new values with the name
+func ByName(name string) (Codec, error) {
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown codec: %s", name)
+}
+
+// NarrowNumbers replaces json.Number and integer values which are exactly represented by float64 with float64,
+// so decoded values are the same as values parsed by encoding/json. Big integers are kept as json.Number
+func NarrowNumbers(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if isBigInteger(value) {
+			return value
+		}
+		if f, err := value.Float64(); err == nil {
+			return f
+		}
+		return value
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return NarrowNumbers(json.Number(fmt.Sprint(value)))
+	case float32:
+		return float64(value)
+	case []interface{}:
+		for idx := range value {
+			value[idx] = NarrowNumbers(value[idx])
+		}
+	case map[string]interface{}:
+		for key := range value {
+			value[key] = NarrowNumbers(value[key])
+		}
+	}
+	return v
+}
+
+func isBigInteger(n json.Number) bool {
+	if strings.ContainsAny(string(n), ".eE") {
+		return false
+	}
+	i, err := n.Int64()
+	return err != nil || i > maxExactInteger || i < -maxExactInteger
+}
@@ -0,0 +1,101 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type codecTestValue struct {
+	ID     interface{} `json:"id"`
+	Result interface{} `json:"result"`
+	Count  int64       `json:"count"`
+}
+
+func codecTestResult() interface{} {
+	items := make([]interface{}, 20)
+	fields := make(map[string]interface{}, 20)
+	for idx := range items {
+		items[idx] = float64(idx * 1000)
+		fields[fmt.Sprintf("field%d", idx)] = float64(-idx * 100000)
+	}
+	return map[string]interface{}{
+		"negative": float64(-9007199254740992),
+		"float":    1.5,
+		"null":     nil,
+		"bool":     true,
+		"text":     "тест",
+		"long":     strings.Repeat("deal", 20000),
+		"items":    items,
+		"fields":   fields,
+	}
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	value := codecTestValue{ID: float64(1), Result: codecTestResult(), Count: 42}
+	for _, name := range []string{"json", "cbor", "msgpack"} {
+		t.Run(name, func(t *testing.T) {
+			c, err := ByName(name)
+			require.NoError(t, err)
+			byID, err := ByID(c.ID())
+			require.NoError(t, err)
+			require.Equal(t, c, byID)
+
+			data, err := c.Marshal(value)
+			require.NoError(t, err)
+			decoded := codecTestValue{}
+			require.NoError(t, c.Unmarshal(data, &decoded))
+			decoded.ID = NarrowNumbers(decoded.ID)
+			decoded.Result = NarrowNumbers(decoded.Result)
+			require.Equal(t, value, decoded)
+
+			require.Error(t, c.Unmarshal(data[:len(data)-1], &codecTestValue{}))
+			require.Error(t, c.Unmarshal(append(data, 0), &codecTestValue{}))
+		})
+	}
+}
+
+func TestCodecsEncoding(t *testing.T) {
+	value := map[string]interface{}{"a": 1, "b": []int{2, 3}}
+	data, err := CBOR{}.Marshal(value)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xa2, 0x61, 'a', 0x01, 0x61, 'b', 0x82, 0x02, 0x03}, data)
+	data, err = MessagePack{}.Marshal(value)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x92, 0x02, 0x03}, data)
+
+	// half precision float and negative integer encoded by other CBOR encoders
+	var decoded interface{}
+	require.NoError(t, CBOR{}.Unmarshal([]byte{0x82, 0xf9, 0x3e, 0x00, 0x38, 0x63}, &decoded))
+	require.Equal(t, []interface{}{1.5, float64(-100)}, NarrowNumbers(decoded))
+	// int16 and float32 encoded by other MessagePack encoders
+	decoded = nil
+	require.NoError(t, MessagePack{}.Unmarshal([]byte{0x92, 0xd1, 0xfc, 0x18, 0xca, 0x3f, 0xc0, 0x00, 0x00}, &decoded))
+	require.Equal(t, []interface{}{float64(-1000), 1.5}, NarrowNumbers(decoded))
+	// maps are decoded as JSON objects
+	decoded = nil
+	require.NoError(t, CBOR{}.Unmarshal([]byte{0xa1, 0x61, 'a', 0xf6}, &decoded))
+	require.Equal(t, map[string]interface{}{"a": nil}, decoded)
+}
+
+func TestNarrowNumbers(t *testing.T) {
+	value := NarrowNumbers([]interface{}{
+		json.Number("1"),
+		json.Number("1.5"),
+		json.Number("9007199254740993"),
+		map[string]interface{}{"epoch": json.Number("-2")},
+		uint64(18446744073709551615),
+		int16(-3),
+	})
+	require.Equal(t, []interface{}{
+		float64(1),
+		1.5,
+		json.Number("9007199254740993"),
+		map[string]interface{}{"epoch": float64(-2)},
+		json.Number("18446744073709551615"),
+		float64(-3),
+	}, value)
+}
@@ -0,0 +1,43 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// JSON encodes values as JSON, so they are readable by other tools
+type JSON struct{}
+
+// ID ...
+func (JSON) ID() byte {
+	return JSONID
+}
+
+// Name ...
+func (JSON) Name() string {
+	return "json"
+}
+
+// Marshal ...
+func (JSON) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal ...
+func (JSON) Unmarshal(data []byte, v interface{}) error {
+	return unmarshalJSON(data, v)
+}
+
+func unmarshalJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("invalid json value: trailing data")
+	}
+	return nil
+}
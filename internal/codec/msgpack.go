@@ -0,0 +1,49 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MessagePack encodes values as MessagePack. Struct fields are named by their json tags
+type MessagePack struct{}
+
+// ID ...
+func (MessagePack) ID() byte {
+	return MessagePackID
+}
+
+// Name ...
+func (MessagePack) Name() string {
+	return "msgpack"
+}
+
+// Marshal ...
+func (MessagePack) Marshal(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	encoder := msgpack.NewEncoder(buf)
+	encoder.SetCustomStructTag("json")
+	// sorted map keys make encoded values deterministic
+	encoder.SetSortMapKeys(true)
+	encoder.UseCompactInts(true)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal ...
+func (MessagePack) Unmarshal(data []byte, v interface{}) error {
+	reader := bytes.NewReader(data)
+	decoder := msgpack.NewDecoder(reader)
+	decoder.SetCustomStructTag("json")
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid msgpack value: %w", err)
+	}
+	if reader.Len() != 0 {
+		return fmt.Errorf("invalid msgpack value: trailing data")
+	}
+	return nil
+}
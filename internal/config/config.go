@@ -6,12 +6,23 @@ import (
 	"io"
 	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/jsonpath"
 
 	"gopkg.in/yaml.v2"
 )
 
 type MethodType string
 type CacheStorage string
+type InvalidationEvent string
+type RedisMode string
+type CompressionAlgorithm string
+type CacheCodec string
+type ParamsGeneratorKind string
+type ParamType string
 
 const (
 	// in seconds
@@ -26,13 +37,55 @@ const (
 	defaultRequestsBatchSize                 = 5
 	defaultRequestsConcurrency               = 10
 	defaultShutdownTimeout                   = 20
+	defaultUpstreamTimeout                   = 60
+	defaultGenesisTimestamp                  = 1598306400
+	defaultBlockDelay                        = 30
+	defaultFinality                          = 900
+	defaultHeadPollPeriod                    = 10
+	defaultRedisPrefix                       = "filecoin:"
+	defaultTieredTTL                         = 5
+	defaultDiskCleanupInterval               = 600
 	CustomMethod                MethodType   = "custom"
 	RegularMethod               MethodType   = "regular"
 	MemoryCacheStorage          CacheStorage = "memory"
 	RedisCacheStorage           CacheStorage = "redis"
+	TieredCacheStorage          CacheStorage = "tiered"
+	DiskCacheStorage            CacheStorage = "disk"
 	RedisPoolSize               int          = 10
 )
 
+const (
+	NewHeadInvalidation InvalidationEvent = "new_head"
+	RedisSingleMode     RedisMode         = "single"
+	RedisSentinelMode   RedisMode         = "sentinel"
+	RedisClusterMode    RedisMode         = "cluster"
+)
+
+const (
+	NoCompression          CompressionAlgorithm = "none"
+	GzipCompression        CompressionAlgorithm = "gzip"
+	defaultGzipCompression                      = 6
+)
+
+const (
+	JSONCodec        CacheCodec = "json"
+	CBORCodec        CacheCodec = "cbor"
+	MessagePackCodec CacheCodec = "msgpack"
+)
+
+const (
+	HeadHeightGenerator ParamsGeneratorKind = "head_height"
+	HeadKeyGenerator    ParamsGeneratorKind = "head_key"
+	ListGenerator       ParamsGeneratorKind = "list"
+)
+
+const (
+	TipSetKeyParam ParamType = "tipset_key"
+	CIDParam       ParamType = "cid"
+	AddressParam   ParamType = "address"
+	BigIntParam    ParamType = "bigint"
+)
+
 var (
 	defaultJWTPermissions = []string{"read"}
 )
@@ -62,15 +115,99 @@ func (c CacheStorage) IsRedis() bool {
 	return c == RedisCacheStorage
 }
 
+func (c CacheStorage) IsTiered() bool {
+	return c == TieredCacheStorage
+}
+
+func (c CacheStorage) IsDisk() bool {
+	return c == DiskCacheStorage
+}
+
 func (c CacheStorage) Valid() error {
 	switch c {
-	case MemoryCacheStorage, RedisCacheStorage:
+	case MemoryCacheStorage, RedisCacheStorage, TieredCacheStorage, DiskCacheStorage:
 		return nil
 	default:
 		return fmt.Errorf("unknown cache storage: %s", c)
 	}
 }
 
+func (e InvalidationEvent) IsNewHead() bool {
+	return e == NewHeadInvalidation
+}
+
+func (e InvalidationEvent) Valid() error {
+	switch e {
+	case "", NewHeadInvalidation:
+		return nil
+	default:
+		return fmt.Errorf("unknown invalidation event: %s", e)
+	}
+}
+
+func (m RedisMode) IsSingle() bool {
+	return m == RedisSingleMode
+}
+
+func (m RedisMode) IsSentinel() bool {
+	return m == RedisSentinelMode
+}
+
+func (m RedisMode) IsCluster() bool {
+	return m == RedisClusterMode
+}
+
+func (m RedisMode) Valid() error {
+	switch m {
+	case RedisSingleMode, RedisSentinelMode, RedisClusterMode:
+		return nil
+	default:
+		return fmt.Errorf("unknown redis mode: %s", m)
+	}
+}
+
+func (a CompressionAlgorithm) IsEnabled() bool {
+	return a != "" && a != NoCompression
+}
+
+func (a CompressionAlgorithm) Valid() error {
+	switch a {
+	case NoCompression, GzipCompression:
+		return nil
+	default:
+		return fmt.Errorf("unknown compression algorithm: %s", a)
+	}
+}
+
+func (c CacheCodec) Valid() error {
+	switch c {
+	case JSONCodec, CBORCodec, MessagePackCodec:
+		return nil
+	case "bson":
+		return fmt.Errorf("bson cache codec is only read for values cached by earlier versions")
+	default:
+		return fmt.Errorf("unknown cache codec: %s", c)
+	}
+}
+
+func (k ParamsGeneratorKind) Valid() error {
+	switch k {
+	case HeadHeightGenerator, HeadKeyGenerator, ListGenerator:
+		return nil
+	default:
+		return fmt.Errorf("unknown params generator: %s", k)
+	}
+}
+
+func (t ParamType) Valid() error {
+	switch t {
+	case TipSetKeyParam, CIDParam, AddressParam, BigIntParam:
+		return nil
+	default:
+		return fmt.Errorf("unknown param type: %s", t)
+	}
+}
+
 func (t *MethodType) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var buf string
 	if err := unmarshal(&buf); err != nil {
@@ -96,8 +233,147 @@ type CacheMethod struct {
 	NoUpdateCache       bool        `yaml:"no_update_cache"`
 	ParamsInCacheByID   []int       `yaml:"params_in_cache_by_id,omitempty"`
 	ParamsInCacheByName []string    `yaml:"params_in_cache_by_name,omitempty"`
+	ParamsInCacheByPath []string    `yaml:"params_in_cache_by_path,omitempty"`
 	Kind                *MethodType `yaml:"kind,omitempty"`
 	ParamsForRequest    interface{} `yaml:"params_for_request,omitempty"`
+	// in seconds
+	TTL      int `yaml:"ttl,omitempty"`
+	MaxStale int `yaml:"max_stale,omitempty"`
+	// position of the epoch parameter. finalized epochs are cached permanently
+	EpochParamByID *int `yaml:"epoch_param_by_id,omitempty"`
+	// in seconds. 0 means epochs within finality are not cached
+	UnfinalizedTTL int `yaml:"unfinalized_ttl,omitempty"`
+	// drop cached responses of requests at the chain head on the event
+	InvalidateOn InvalidationEvent `yaml:"invalidate_on,omitempty"`
+	// json path of the TipSetKey param. requests with the param null, missing or empty address the chain head.
+	// the last param by default
+	HeadParam string `yaml:"head_param,omitempty"`
+	// compress cached responses regardless of their size
+	Compress bool `yaml:"compress,omitempty"`
+	// params of the types are canonical in cache keys. other params are used as is
+	NormalizeParams []NormalizeParam `yaml:"normalize_params,omitempty"`
+	// serve responses within max_stale and refresh them in background
+	StaleWhileRevalidate bool `yaml:"stale_while_revalidate,omitempty"`
+	// serve responses within max_stale if the upstream fails
+	StaleIfError bool `yaml:"stale_if_error,omitempty"`
+	// responses are cached only if they match the conditions
+	CacheIf ResponseConditions `yaml:"cache_if,omitempty"`
+	// error responses with the codes are cached for error_ttl seconds
+	CacheErrors []int `yaml:"cache_errors,omitempty"`
+	ErrorTTL    int   `yaml:"error_ttl,omitempty"`
+	// requests with params matching any of the conditions pass through uncached
+	SkipWhen []SkipCondition `yaml:"skip_when,omitempty"`
+	// custom methods only. "${name}" values of params_for_request are replaced by values of the generator
+	// evaluated on every update. requests are made for every combination of generated values
+	ParamsGenerators map[string]ParamsGenerator `yaml:"params_generators,omitempty"`
+}
+
+// SkipCondition matches requests by a param. Exactly one of empty, equals and within_head should be set
+type SkipCondition struct {
+	// json path of the param, e.g. $[1]
+	Param string `yaml:"param"`
+	// the param is null, missing or empty string, array or object
+	Empty bool `yaml:"empty,omitempty"`
+	// the param equals the string, number or boolean, e.g. latest
+	Equals interface{} `yaml:"equals,omitempty"`
+	// the epoch param is within the number of epochs of the chain head
+	WithinHead *int `yaml:"within_head,omitempty"`
+}
+
+func (c SkipCondition) Valid() error {
+	if _, err := jsonpath.Parse(c.Param); err != nil {
+		return err
+	}
+	conditions := 0
+	if c.Empty {
+		conditions++
+	}
+	if c.Equals != nil {
+		conditions++
+		switch c.Equals.(type) {
+		case string, int, float64, bool:
+		default:
+			return fmt.Errorf("equals of param %s should be string, number or boolean", c.Param)
+		}
+	}
+	if c.WithinHead != nil {
+		conditions++
+		if *c.WithinHead < 0 {
+			return fmt.Errorf("within_head of param %s should not be negative", c.Param)
+		}
+	}
+	if conditions != 1 {
+		return fmt.Errorf("exactly one of empty, equals and within_head should be set for param %s", c.Param)
+	}
+	return nil
+}
+
+// NormalizeParam makes equivalent values of the param the same in cache keys
+type NormalizeParam struct {
+	// json path of the param, e.g. $[1]
+	Param string `yaml:"param"`
+	// available: tipset_key|cid|address|bigint
+	Type ParamType `yaml:"type"`
+}
+
+func (n NormalizeParam) Valid() error {
+	if _, err := jsonpath.Parse(n.Param); err != nil {
+		return err
+	}
+	return n.Type.Valid()
+}
+
+// ParamsGenerator generates values of params_for_request placeholders
+type ParamsGenerator struct {
+	// available: head_height|head_key|list
+	Kind ParamsGeneratorKind `yaml:"kind"`
+	// head_height only. heights from head - offset down to head - offset - count + 1. 0 count means 1
+	Offset int `yaml:"offset,omitempty"`
+	Count  int `yaml:"count,omitempty"`
+	// list only
+	Values []interface{} `yaml:"values,omitempty"`
+}
+
+// ResponseConditions are conditions of successful responses to be cached
+type ResponseConditions struct {
+	// skip null results and empty strings, arrays and objects
+	NotEmpty bool `yaml:"not_empty,omitempty"`
+	// json path which should exist in the result, e.g. $.Receipt
+	PathExists string `yaml:"path_exists,omitempty"`
+	// in bytes of the JSON encoded result. 0 means no limit
+	MaxSize int `yaml:"max_size,omitempty"`
+}
+
+// IsPattern reports whether the name is a glob pattern, e.g. Filecoin.StateMiner*, or a regular expression
+// enclosed in slashes, e.g. /^eth_get.*$/
+func (c CacheMethod) IsPattern() bool {
+	return c.IsRegexp() || strings.ContainsAny(c.Name, "*?[")
+}
+
+// IsRegexp reports whether the name is a regular expression enclosed in slashes
+func (c CacheMethod) IsRegexp() bool {
+	return len(c.Name) > 1 && strings.HasPrefix(c.Name, "/") && strings.HasSuffix(c.Name, "/")
+}
+
+// Regexp returns the regular expression of the name matching whole method names
+func (c CacheMethod) Regexp() (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + c.Name[1:len(c.Name)-1] + ")$")
+}
+
+func (c CacheMethod) validPattern() error {
+	if c.Kind.IsCustom() {
+		return fmt.Errorf("custom method %s should not be a pattern", c.Name)
+	}
+	if c.IsRegexp() {
+		if _, err := c.Regexp(); err != nil {
+			return fmt.Errorf("invalid method pattern %s: %w", c.Name, err)
+		}
+		return nil
+	}
+	if _, err := path.Match(c.Name, ""); err != nil {
+		return fmt.Errorf("invalid method pattern %s: %w", c.Name, err)
+	}
+	return nil
 }
 
 func (c *CacheMethod) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -115,17 +391,143 @@ func (c *CacheMethod) UnmarshalYAML(unmarshal func(interface{}) error) error {
 type MemoryCacheSettings struct {
 	DefaultExpiration int `yaml:"expiration,omitempty"`
 	CleanupInterval   int `yaml:"cleanup_interval,omitempty"`
+	// LRU eviction is used if any limit is set
+	MaxBytes   int64 `yaml:"max_bytes,omitempty"`
+	MaxEntries int   `yaml:"max_entries,omitempty"`
+	// restore the cache after restarts
+	Snapshot MemorySnapshotSettings `yaml:"snapshot,omitempty"`
+}
+
+// MemorySnapshotSettings configures memory cache snapshots restored on startup
+type MemorySnapshotSettings struct {
+	// snapshots are disabled if the path is empty
+	Path string `yaml:"path,omitempty"`
+	// in seconds. 0 means the snapshot is saved on shutdown only
+	Interval int `yaml:"interval,omitempty"`
+	// in seconds. older snapshots are skipped. 0 means no limit
+	MaxAge int `yaml:"max_age,omitempty"`
+}
+
+func (s MemorySnapshotSettings) IsEnabled() bool {
+	return s.Path != ""
+}
+
+// IsBounded reports whether memory cache size is limited
+func (s MemoryCacheSettings) IsBounded() bool {
+	return s.MaxBytes > 0 || s.MaxEntries > 0
 }
 
 type RedisCacheSettings struct {
+	// available: single|sentinel|cluster
+	Mode RedisMode `yaml:"mode,omitempty"`
+	// single mode only
 	URI      string `yaml:"uri,omitempty"`
 	PoolSize int    `yaml:"pool_size,omitempty"`
+	// sentinel and cluster modes only
+	Addresses        []string `yaml:"addresses,omitempty"`
+	MasterName       string   `yaml:"master_name,omitempty"`
+	Username         string   `yaml:"username,omitempty"`
+	Password         string   `yaml:"password,omitempty"`
+	SentinelPassword string   `yaml:"sentinel_password,omitempty"`
+	DB               int      `yaml:"db,omitempty"`
+	// all modes
+	TLS RedisTLSSettings `yaml:"tls,omitempty"`
+	// every cached value is stored under its own key with the prefix
+	Prefix string `yaml:"prefix,omitempty"`
+	// move values from the legacy single hash storage on startup
+	MigrateLegacyHash bool `yaml:"migrate_legacy_hash,omitempty"`
+}
+
+type ChainSettings struct {
+	// unix timestamp
+	GenesisTimestamp int64 `yaml:"genesis_timestamp,omitempty"`
+	// in seconds
+	BlockDelay int `yaml:"block_delay,omitempty"`
+	// in epochs
+	Finality int `yaml:"finality,omitempty"`
+	// in seconds
+	HeadPollPeriod int `yaml:"head_poll_period,omitempty"`
+}
+
+type RedisTLSSettings struct {
+	// rediss:// uri enables TLS in the single mode as well
+	Enabled bool `yaml:"enabled,omitempty"`
+	// PEM encoded CA bundle. system roots are used if empty
+	CAFile string `yaml:"ca_file,omitempty"`
+	// PEM encoded client certificate and key for mutual TLS
+	CertFile           string `yaml:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+func (s RedisCacheSettings) Validate() error {
+	if err := s.Mode.Valid(); err != nil {
+		return err
+	}
+	if (s.TLS.CertFile == "") != (s.TLS.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file should be set together for redis tls")
+	}
+	switch s.Mode {
+	case RedisSingleMode:
+		if s.URI == "" {
+			return fmt.Errorf("uri is required parameter for redis cache")
+		}
+		if _, err := url.Parse(s.URI); err != nil {
+			return fmt.Errorf("cannot parse redis url: %w", err)
+		}
+	case RedisSentinelMode:
+		if len(s.Addresses) == 0 {
+			return fmt.Errorf("addresses is required parameter for redis sentinel cache")
+		}
+		if s.MasterName == "" {
+			return fmt.Errorf("master_name is required parameter for redis sentinel cache")
+		}
+	case RedisClusterMode:
+		if len(s.Addresses) == 0 {
+			return fmt.Errorf("addresses is required parameter for redis cluster cache")
+		}
+		if s.DB != 0 {
+			return fmt.Errorf("db is not supported by redis cluster cache")
+		}
+	}
+	return nil
+}
+
+type DiskCacheSettings struct {
+	// database file path
+	Path string `yaml:"path,omitempty"`
+	// in seconds. expired values are removed every cleanup_interval
+	CleanupInterval int `yaml:"cleanup_interval,omitempty"`
+}
+
+// TieredCacheSettings configures memory cache in front of redis cache
+type TieredCacheSettings struct {
+	// in seconds. memory cache entries are served at most ttl seconds
+	TTL int `yaml:"ttl,omitempty"`
+	// 0 means no limit
+	MaxBytes   int64 `yaml:"max_bytes,omitempty"`
+	MaxEntries int   `yaml:"max_entries,omitempty"`
+}
+
+type CompressionSettings struct {
+	Algorithm CompressionAlgorithm `yaml:"algorithm,omitempty"`
+	Level     int                  `yaml:"level,omitempty"`
+	// in bytes. values of at least min_size are compressed. 0 means only methods with compress are compressed
+	MinSize int `yaml:"min_size,omitempty"`
 }
 
 type CacheSettings struct {
-	Storage CacheStorage        `yaml:"storage,omitempty"`
-	Memory  MemoryCacheSettings `yaml:"memory,omitempty"`
-	Redis   RedisCacheSettings  `yaml:"redis,omitempty"`
+	Storage     CacheStorage        `yaml:"storage,omitempty"`
+	Memory      MemoryCacheSettings `yaml:"memory,omitempty"`
+	Redis       RedisCacheSettings  `yaml:"redis,omitempty"`
+	Tiered      TieredCacheSettings `yaml:"tiered,omitempty"`
+	Disk        DiskCacheSettings   `yaml:"disk,omitempty"`
+	Compression CompressionSettings `yaml:"compression,omitempty"`
+	// values are encoded with the codec. values encoded with other codecs are still decoded
+	Codec CacheCodec `yaml:"codec,omitempty"`
+	// JSONL file of requests replayed through the upstream at startup. the proxy is not ready until they are cached
+	WarmupFile string `yaml:"warmup_file,omitempty"`
 }
 
 type Config struct {
@@ -141,8 +543,10 @@ type Config struct {
 	RequestsBatchSize       int           `yaml:"requests_batch_size"`
 	RequestsConcurrency     int           `yaml:"requests_concurrency"`
 	ShutdownTimeout         int           `yaml:"shutdown_timeout"`
+	UpstreamTimeout         int           `yaml:"upstream_timeout"`
 	ProxyURL                string        `yaml:"proxy_url"`
 	CacheSettings           CacheSettings `yaml:"cache_settings,omitempty"`
+	Chain                   ChainSettings `yaml:"chain,omitempty"`
 	LogLevel                string        `yaml:"log_level"`
 	LogPrettyPrint          bool          `yaml:"log_pretty_print"`
 	DebugHTTPRequest        bool          `yaml:"debug_http_request,omitempty"`
@@ -218,18 +622,54 @@ func (c *Config) Init() {
 	if c.ShutdownTimeout == 0 {
 		c.ShutdownTimeout = defaultShutdownTimeout
 	}
+	if c.UpstreamTimeout == 0 {
+		c.UpstreamTimeout = defaultUpstreamTimeout
+	}
 	if c.CacheSettings.Storage == "" {
 		c.CacheSettings.Storage = MemoryCacheStorage
 	}
+	if c.CacheSettings.Redis.Mode == "" {
+		c.CacheSettings.Redis.Mode = RedisSingleMode
+	}
 	if c.CacheSettings.Redis.PoolSize == 0 {
 		c.CacheSettings.Redis.PoolSize = RedisPoolSize
 	}
+	if c.CacheSettings.Redis.Prefix == "" {
+		c.CacheSettings.Redis.Prefix = defaultRedisPrefix
+	}
+	if c.CacheSettings.Disk.CleanupInterval == 0 {
+		c.CacheSettings.Disk.CleanupInterval = defaultDiskCleanupInterval
+	}
+	if c.CacheSettings.Tiered.TTL == 0 {
+		c.CacheSettings.Tiered.TTL = defaultTieredTTL
+	}
+	if c.CacheSettings.Compression.Algorithm == "" {
+		c.CacheSettings.Compression.Algorithm = NoCompression
+	}
+	if c.CacheSettings.Compression.Level == 0 {
+		c.CacheSettings.Compression.Level = defaultGzipCompression
+	}
+	if c.CacheSettings.Codec == "" {
+		c.CacheSettings.Codec = JSONCodec
+	}
 	if c.CacheSettings.Memory.CleanupInterval == 0 {
 		c.CacheSettings.Memory.CleanupInterval = DefaultCacheCleanupInterval
 	}
 	if c.CacheSettings.Memory.DefaultExpiration == 0 {
 		c.CacheSettings.Memory.DefaultExpiration = DefaultCacheExpiration
 	}
+	if c.Chain.GenesisTimestamp == 0 {
+		c.Chain.GenesisTimestamp = defaultGenesisTimestamp
+	}
+	if c.Chain.BlockDelay == 0 {
+		c.Chain.BlockDelay = defaultBlockDelay
+	}
+	if c.Chain.Finality == 0 {
+		c.Chain.Finality = defaultFinality
+	}
+	if c.Chain.HeadPollPeriod == 0 {
+		c.Chain.HeadPollPeriod = defaultHeadPollPeriod
+	}
 	for idx := range c.CacheMethods {
 		method := c.CacheMethods[idx]
 		if method.Kind == nil {
@@ -256,6 +696,89 @@ func (c *Config) Validate() error {
 		if method.Kind.IsRegular() && method.ParamsForRequest != nil {
 			return fmt.Errorf("regular method type should not have been set with params_for_request")
 		}
+		if method.TTL < 0 {
+			return fmt.Errorf("ttl for method %s should not be negative", method.Name)
+		}
+		if method.MaxStale < 0 {
+			return fmt.Errorf("max_stale for method %s should not be negative", method.Name)
+		}
+		if method.MaxStale > 0 && method.TTL == 0 {
+			return fmt.Errorf("max_stale for method %s requires ttl", method.Name)
+		}
+		if (method.StaleWhileRevalidate || method.StaleIfError) && method.MaxStale == 0 {
+			return fmt.Errorf("stale_while_revalidate and stale_if_error for method %s require max_stale", method.Name)
+		}
+		if method.EpochParamByID != nil && *method.EpochParamByID < 0 {
+			return fmt.Errorf("epoch_param_by_id for method %s should not be negative", method.Name)
+		}
+		if method.UnfinalizedTTL < 0 {
+			return fmt.Errorf("unfinalized_ttl for method %s should not be negative", method.Name)
+		}
+		if method.IsPattern() {
+			if err := method.validPattern(); err != nil {
+				return err
+			}
+		}
+		for _, idx := range method.ParamsInCacheByID {
+			if idx < 0 {
+				return fmt.Errorf("params_in_cache_by_id for method %s should not be negative", method.Name)
+			}
+		}
+		// by_id and by_name select params of different shapes, a path selects params of any shape
+		if len(method.ParamsInCacheByPath) > 0 && (len(method.ParamsInCacheByID) > 0 || len(method.ParamsInCacheByName) > 0) {
+			return fmt.Errorf("params_in_cache_by_path for method %s cannot be combined with params_in_cache_by_id or params_in_cache_by_name", method.Name)
+		}
+		for _, path := range method.ParamsInCacheByPath {
+			if _, err := jsonpath.Parse(path); err != nil {
+				return fmt.Errorf("params_in_cache_by_path for method %s: %w", method.Name, err)
+			}
+		}
+		for _, param := range method.NormalizeParams {
+			if err := param.Valid(); err != nil {
+				return fmt.Errorf("normalize_params for method %s: %w", method.Name, err)
+			}
+		}
+		for _, condition := range method.SkipWhen {
+			if err := condition.Valid(); err != nil {
+				return fmt.Errorf("skip_when for method %s: %w", method.Name, err)
+			}
+		}
+		if method.CacheIf.PathExists != "" {
+			if _, err := jsonpath.Parse(method.CacheIf.PathExists); err != nil {
+				return fmt.Errorf("cache_if path_exists for method %s: %w", method.Name, err)
+			}
+		}
+		if method.CacheIf.MaxSize < 0 {
+			return fmt.Errorf("cache_if max_size for method %s should not be negative", method.Name)
+		}
+		if len(method.CacheErrors) > 0 && method.ErrorTTL <= 0 {
+			return fmt.Errorf("cache_errors for method %s require positive error_ttl", method.Name)
+		}
+		if len(method.ParamsGenerators) > 0 && !method.Kind.IsCustom() {
+			return fmt.Errorf("params_generators for method %s require custom method type", method.Name)
+		}
+		for name, generator := range method.ParamsGenerators {
+			if err := generator.Kind.Valid(); err != nil {
+				return fmt.Errorf("params generator %s for method %s: %w", name, method.Name, err)
+			}
+			if generator.Offset < 0 || generator.Count < 0 {
+				return fmt.Errorf("params generator %s for method %s: offset and count should not be negative", name, method.Name)
+			}
+			if generator.Kind == ListGenerator && len(generator.Values) == 0 {
+				return fmt.Errorf("params generator %s for method %s: values are required", name, method.Name)
+			}
+		}
+		if err := method.InvalidateOn.Valid(); err != nil {
+			return err
+		}
+		if method.HeadParam != "" {
+			if !method.InvalidateOn.IsNewHead() {
+				return fmt.Errorf("head_param for method %s requires invalidate_on: %s", method.Name, NewHeadInvalidation)
+			}
+			if _, err := jsonpath.Parse(method.HeadParam); err != nil {
+				return fmt.Errorf("head_param for method %s: %w", method.Name, err)
+			}
+		}
 	}
 	if c.ProxyURL == "" {
 		return fmt.Errorf("proxy_url is mandatory parameter")
@@ -266,14 +789,41 @@ func (c *Config) Validate() error {
 	if err := c.CacheSettings.Storage.Valid(); err != nil {
 		return err
 	}
-	if c.CacheSettings.Storage.IsRedis() {
-		if c.CacheSettings.Redis.URI == "" {
-			return fmt.Errorf("uri is required parameter for redis cache")
-		}
-		if _, err := url.Parse(c.CacheSettings.Redis.URI); err != nil {
-			return fmt.Errorf("cannot parse redis url: %w", err)
+	if c.CacheSettings.Storage.IsRedis() || c.CacheSettings.Storage.IsTiered() {
+		if err := c.CacheSettings.Redis.Validate(); err != nil {
+			return err
 		}
 	}
+	if c.CacheSettings.Storage.IsDisk() && c.CacheSettings.Disk.Path == "" {
+		return fmt.Errorf("path is required parameter for disk cache")
+	}
+	if tiered := c.CacheSettings.Tiered; tiered.TTL < 0 || tiered.MaxBytes < 0 || tiered.MaxEntries < 0 {
+		return fmt.Errorf("tiered ttl, max_bytes and max_entries should not be negative")
+	}
+	if err := c.CacheSettings.Compression.Algorithm.Valid(); err != nil {
+		return err
+	}
+	if level := c.CacheSettings.Compression.Level; level < 1 || level > 9 {
+		return fmt.Errorf("compression level should be between 1 and 9")
+	}
+	if c.CacheSettings.Compression.MinSize < 0 {
+		return fmt.Errorf("compression min_size should not be negative")
+	}
+	if err := c.CacheSettings.Codec.Valid(); err != nil {
+		return err
+	}
+	if c.CacheSettings.Memory.MaxBytes < 0 || c.CacheSettings.Memory.MaxEntries < 0 {
+		return fmt.Errorf("max_bytes and max_entries should not be negative")
+	}
+	if snapshot := c.CacheSettings.Memory.Snapshot; snapshot.Interval < 0 || snapshot.MaxAge < 0 {
+		return fmt.Errorf("snapshot interval and max_age should not be negative")
+	}
+	if c.Chain.BlockDelay < 0 || c.Chain.Finality < 0 {
+		return fmt.Errorf("block_delay and finality should not be negative")
+	}
+	if c.Chain.HeadPollPeriod < 0 {
+		return fmt.Errorf("head_poll_period should not be negative")
+	}
 	if c.JWTSecret == "" && c.JWTSecretBase64 == "" {
 		return fmt.Errorf("jwt secret is mandatory parameter")
 	}
@@ -86,6 +86,154 @@ cache_methods:
   params_in_cache_by_name:
     - %s
 `, proxyURL, token, methodName, strconv.Itoa(paramInCacheID), paramInCacheName)
+	configMethodTTL = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+cache_methods:
+- name: %s
+  cache_by_params: true
+  ttl: 60
+  max_stale: 30
+  epoch_param_by_id: 0
+  unfinalized_ttl: 5
+`, proxyURL, token, methodName)
+	configMethodMaxStaleWithoutTTL = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+cache_methods:
+- name: %s
+  cache_by_params: true
+  max_stale: 30
+`, proxyURL, token, methodName)
+	configRedisSentinel = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+cache_settings:
+  storage: redis
+  redis:
+    mode: sentinel
+    master_name: master
+    addresses:
+      - 127.0.0.1:26379
+      - 127.0.0.2:26379
+`, proxyURL, token)
+	configRedisClusterWithoutAddresses = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+cache_settings:
+  storage: redis
+  redis:
+    mode: cluster
+`, proxyURL, token)
+	configTiered = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+cache_settings:
+  storage: tiered
+  redis:
+    uri: %s
+  tiered:
+    max_entries: 100
+`, proxyURL, token, redisURI)
+	configStaleWithoutMaxStale = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+cache_methods:
+- name: %s
+  ttl: 60
+  stale_if_error: true
+`, proxyURL, token, methodName)
+	configDiskWithoutPath = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+cache_settings:
+  storage: disk
+`, proxyURL, token)
+	configUnknownCompression = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+cache_settings:
+  compression:
+    algorithm: lzma
+`, proxyURL, token)
+	configUnknownCodec = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+cache_settings:
+  codec: xml
+`, proxyURL, token)
+	configCacheConditions = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+cache_methods:
+- name: %s
+  cache_if:
+    not_empty: true
+    path_exists: $.Receipt
+    max_size: 1024
+  cache_errors: [-32000]
+  error_ttl: 5
+`, proxyURL, token, methodName)
+	configParamsGenerators = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+cache_methods:
+- name: %s
+  kind: custom
+  params_for_request: ["${height}", "${miner}"]
+  params_generators:
+    height:
+      kind: head_height
+      count: 20
+    miner:
+      kind: list
+      values: [f01234, f05678]
+`, proxyURL, token, methodName)
+	configParamsByPath = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+cache_methods:
+- name: %s
+  cache_by_params: true
+  params_in_cache_by_path:
+    - $[0].To
+    - $[0]['Method']
+`, proxyURL, token, methodName)
+	configNormalizeParams = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+cache_methods:
+- name: %s
+  cache_by_params: true
+  normalize_params:
+    - param: $[0]
+      type: address
+    - param: $[1]
+      type: tipset_key
+`, proxyURL, token, methodName)
+	configSkipWhen = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+cache_methods:
+- name: %s
+  cache_by_params: true
+  skip_when:
+    - param: $[1]
+      empty: true
+    - param: $[0]
+      equals: latest
+    - param: $[0]
+      within_head: 10
+`, proxyURL, token, methodName)
+	configHeadParam = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+cache_methods:
+- name: %s
+  cache_by_params: true
+  invalidate_on: new_head
+  head_param: $[1]
+`, proxyURL, token, methodName)
 	configParamsWrongCacheStorage = fmt.Sprintf(`
 proxy_url: %s
 jwt_secret: %s
@@ -110,6 +258,7 @@ func TestNewConfigCacheParamsByID(t *testing.T) {
 	require.Equal(t, config.CacheMethods[0].ParamsInCacheByID[0], paramInCacheID)
 	require.Equal(t, config.CacheSettings.Memory.DefaultExpiration, 0)
 	require.Equal(t, config.CacheSettings.Memory.CleanupInterval, -1)
+	require.Equal(t, config.Chain.Finality, defaultFinality)
 	require.True(t, config.CacheMethods[0].Kind.IsCustom())
 }
 
@@ -135,6 +284,7 @@ func TestNewConfigCacheParamsByIDAndNameRegular(t *testing.T) {
 	require.NoError(t, err, err)
 	require.True(t, config.CacheMethods[0].Kind.IsRegular())
 	require.True(t, config.CacheSettings.Storage.IsRedis())
+	require.Equal(t, defaultRedisPrefix, config.CacheSettings.Redis.Prefix)
 }
 
 func TestNewConfigCacheParamsWrongCacheStorage(t *testing.T) {
@@ -146,3 +296,201 @@ func TestNewConfigCacheParamsByIDWrongMethodKind(t *testing.T) {
 	_, err := New(strings.NewReader(configParamsByIDAndNameWrongMethodKind))
 	require.Error(t, err, err)
 }
+
+func TestNewConfigMethodTTL(t *testing.T) {
+	config, err := New(strings.NewReader(configMethodTTL))
+	require.NoError(t, err, err)
+	require.NoError(t, config.Validate())
+	require.Equal(t, 60, config.CacheMethods[0].TTL)
+	require.Equal(t, 30, config.CacheMethods[0].MaxStale)
+	require.Equal(t, 0, *config.CacheMethods[0].EpochParamByID)
+	require.Equal(t, 5, config.CacheMethods[0].UnfinalizedTTL)
+}
+
+func TestNewConfigMethodMaxStaleWithoutTTL(t *testing.T) {
+	config, err := New(strings.NewReader(configMethodMaxStaleWithoutTTL))
+	require.NoError(t, err, err)
+	require.Error(t, config.Validate())
+}
+
+func TestNewConfigRedisSentinel(t *testing.T) {
+	config, err := New(strings.NewReader(configRedisSentinel))
+	require.NoError(t, err, err)
+	require.NoError(t, config.Validate())
+	require.True(t, config.CacheSettings.Redis.Mode.IsSentinel())
+	require.Len(t, config.CacheSettings.Redis.Addresses, 2)
+}
+
+func TestNewConfigRedisClusterWithoutAddresses(t *testing.T) {
+	config, err := New(strings.NewReader(configRedisClusterWithoutAddresses))
+	require.NoError(t, err, err)
+	require.Error(t, config.Validate())
+}
+
+func TestNewConfigCompression(t *testing.T) {
+	config, err := New(strings.NewReader(configRedisSentinel))
+	require.NoError(t, err, err)
+	require.False(t, config.CacheSettings.Compression.Algorithm.IsEnabled())
+	require.Equal(t, 6, config.CacheSettings.Compression.Level)
+
+	config, err = New(strings.NewReader(configUnknownCompression))
+	require.NoError(t, err, err)
+	require.Error(t, config.Validate())
+}
+
+func TestNewConfigCodec(t *testing.T) {
+	config, err := New(strings.NewReader(configRedisSentinel))
+	require.NoError(t, err, err)
+	require.Equal(t, JSONCodec, config.CacheSettings.Codec)
+
+	config, err = New(strings.NewReader(configUnknownCodec))
+	require.NoError(t, err, err)
+	require.Error(t, config.Validate())
+	// bson values are only read
+	config.CacheSettings.Codec = "bson"
+	require.Error(t, config.Validate())
+}
+
+func TestNewConfigTiered(t *testing.T) {
+	config, err := New(strings.NewReader(configTiered))
+	require.NoError(t, err, err)
+	require.NoError(t, config.Validate())
+	require.True(t, config.CacheSettings.Storage.IsTiered())
+	require.Equal(t, 5, config.CacheSettings.Tiered.TTL)
+	require.Equal(t, 100, config.CacheSettings.Tiered.MaxEntries)
+}
+
+func TestNewConfigDiskWithoutPath(t *testing.T) {
+	config, err := New(strings.NewReader(configDiskWithoutPath))
+	require.NoError(t, err, err)
+	require.True(t, config.CacheSettings.Storage.IsDisk())
+	require.Error(t, config.Validate())
+}
+
+func TestNewConfigStaleWithoutMaxStale(t *testing.T) {
+	config, err := New(strings.NewReader(configStaleWithoutMaxStale))
+	require.NoError(t, err, err)
+	require.True(t, config.CacheMethods[0].StaleIfError)
+	require.Error(t, config.Validate())
+}
+
+func TestNewConfigCacheConditions(t *testing.T) {
+	config, err := New(strings.NewReader(configCacheConditions))
+	require.NoError(t, err, err)
+	require.NoError(t, config.Validate())
+	method := config.CacheMethods[0]
+	require.Equal(t, ResponseConditions{NotEmpty: true, PathExists: "$.Receipt", MaxSize: 1024}, method.CacheIf)
+	require.Equal(t, []int{-32000}, method.CacheErrors)
+	require.Equal(t, 5, method.ErrorTTL)
+
+	config.CacheMethods[0].CacheIf.PathExists = "Receipt"
+	require.Error(t, config.Validate())
+	config.CacheMethods[0].CacheIf.PathExists = ""
+	config.CacheMethods[0].ErrorTTL = 0
+	require.Error(t, config.Validate())
+}
+
+func TestNewConfigParamsGenerators(t *testing.T) {
+	config, err := New(strings.NewReader(configParamsGenerators))
+	require.NoError(t, err, err)
+	require.NoError(t, config.Validate())
+	generators := config.CacheMethods[0].ParamsGenerators
+	require.Equal(t, ParamsGenerator{Kind: HeadHeightGenerator, Count: 20}, generators["height"])
+	require.Equal(t, []interface{}{"f01234", "f05678"}, generators["miner"].Values)
+
+	generators["miner"] = ParamsGenerator{Kind: ListGenerator}
+	require.Error(t, config.Validate())
+	generators["miner"] = ParamsGenerator{Kind: "random"}
+	require.Error(t, config.Validate())
+}
+
+func TestNewConfigParamsByPath(t *testing.T) {
+	config, err := New(strings.NewReader(configParamsByPath))
+	require.NoError(t, err, err)
+	require.NoError(t, config.Validate())
+	require.Equal(t, []string{"$[0].To", "$[0]['Method']"}, config.CacheMethods[0].ParamsInCacheByPath)
+
+	config.CacheMethods[0].ParamsInCacheByPath = []string{"$[0]."}
+	require.Error(t, config.Validate())
+	config.CacheMethods[0].ParamsInCacheByPath = []string{"$[0].To"}
+	config.CacheMethods[0].ParamsInCacheByID = []int{1}
+	require.Error(t, config.Validate())
+	config.CacheMethods[0].ParamsInCacheByPath = nil
+	config.CacheMethods[0].ParamsInCacheByID = []int{-1}
+	require.Error(t, config.Validate())
+}
+
+func TestNewConfigMethodPatterns(t *testing.T) {
+	config, err := New(strings.NewReader(configCacheConditions))
+	require.NoError(t, err, err)
+	for name, valid := range map[string]bool{
+		"Filecoin.StateMiner*": true,
+		"/^eth_get.*$/":        true,
+		"Filecoin.State[":      false,
+		"/eth_get(/":           false,
+	} {
+		config.CacheMethods[0].Name = name
+		require.True(t, config.CacheMethods[0].IsPattern(), name)
+		if valid {
+			require.NoError(t, config.Validate(), name)
+		} else {
+			require.Error(t, config.Validate(), name)
+		}
+	}
+	config.CacheMethods[0].Name = "Filecoin.ChainHead"
+	require.False(t, config.CacheMethods[0].IsPattern())
+}
+
+func TestNewConfigNormalizeParams(t *testing.T) {
+	config, err := New(strings.NewReader(configNormalizeParams))
+	require.NoError(t, err, err)
+	require.NoError(t, config.Validate())
+	require.Equal(t, []NormalizeParam{
+		{Param: "$[0]", Type: AddressParam},
+		{Param: "$[1]", Type: TipSetKeyParam},
+	}, config.CacheMethods[0].NormalizeParams)
+
+	for _, param := range []NormalizeParam{
+		{Param: "1", Type: CIDParam},
+		{Param: "$[0]", Type: "epoch"},
+		{Param: "$[0]"},
+	} {
+		config.CacheMethods[0].NormalizeParams = []NormalizeParam{param}
+		require.Error(t, config.Validate())
+	}
+}
+
+func TestNewConfigSkipWhen(t *testing.T) {
+	config, err := New(strings.NewReader(configSkipWhen))
+	require.NoError(t, err, err)
+	require.NoError(t, config.Validate())
+	withinHead := 10
+	require.Equal(t, []SkipCondition{
+		{Param: "$[1]", Empty: true},
+		{Param: "$[0]", Equals: "latest"},
+		{Param: "$[0]", WithinHead: &withinHead},
+	}, config.CacheMethods[0].SkipWhen)
+
+	for _, condition := range []SkipCondition{
+		{Param: "1", Empty: true},
+		{Param: "$[0]"},
+		{Param: "$[0]", Empty: true, Equals: "latest"},
+		{Param: "$[0]", Equals: []interface{}{}},
+	} {
+		config.CacheMethods[0].SkipWhen = []SkipCondition{condition}
+		require.Error(t, config.Validate())
+	}
+}
+
+func TestNewConfigHeadParam(t *testing.T) {
+	config, err := New(strings.NewReader(configHeadParam))
+	require.NoError(t, err, err)
+	require.NoError(t, config.Validate())
+	require.Equal(t, "$[1]", config.CacheMethods[0].HeadParam)
+
+	config.CacheMethods[0].HeadParam = "1"
+	require.Error(t, config.Validate())
+	config.CacheMethods[0].HeadParam = "$[1]"
+	config.CacheMethods[0].InvalidateOn = ""
+	require.Error(t, config.Validate())
+}
@@ -0,0 +1,163 @@
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// step selects a map key or an array index
+type step struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// Path selects a value within values decoded from JSON. Supported syntax is the root $ followed by
+// .key, ['key'] and [index] selectors, e.g. $.Message.To or $[0]['Cid']
+type Path struct {
+	text  string
+	steps []step
+}
+
+// Parse parses the path
+func Parse(text string) (Path, error) {
+	path := Path{text: text}
+	if !strings.HasPrefix(text, "$") {
+		return path, fmt.Errorf("json path %q should start with $", text)
+	}
+	rest := text[1:]
+	for rest != "" {
+		var s step
+		var err error
+		switch rest[0] {
+		case '.':
+			s, rest, err = parseKey(rest[1:])
+		case '[':
+			s, rest, err = parseBracket(rest[1:])
+		default:
+			err = fmt.Errorf("unexpected %q", rest[0])
+		}
+		if err != nil {
+			return path, fmt.Errorf("invalid json path %q: %w", text, err)
+		}
+		path.steps = append(path.steps, s)
+	}
+	return path, nil
+}
+
+// MustParse parses the path and panics on errors
+func MustParse(text string) Path {
+	path, err := Parse(text)
+	if err != nil {
+		panic(err)
+	}
+	return path
+}
+
+func parseKey(text string) (step, string, error) {
+	end := strings.IndexAny(text, ".[")
+	if end < 0 {
+		end = len(text)
+	}
+	if end == 0 {
+		return step{}, "", fmt.Errorf("empty key")
+	}
+	return step{key: text[:end]}, text[end:], nil
+}
+
+func parseBracket(text string) (step, string, error) {
+	if text != "" && (text[0] == '\'' || text[0] == '"') {
+		quote := text[0]
+		end := strings.IndexByte(text[1:], quote)
+		if end < 0 || len(text) < end+3 || text[end+2] != ']' {
+			return step{}, "", fmt.Errorf("unterminated key")
+		}
+		return step{key: text[1 : end+1]}, text[end+3:], nil
+	}
+	end := strings.IndexByte(text, ']')
+	if end < 0 {
+		return step{}, "", fmt.Errorf("unterminated index")
+	}
+	index, err := strconv.Atoi(text[:end])
+	if err != nil || index < 0 {
+		return step{}, "", fmt.Errorf("invalid index %q", text[:end])
+	}
+	return step{index: index, isIndex: true}, text[end+1:], nil
+}
+
+// Get returns the value selected by the path. false means there is no such value
+func (p Path) Get(value interface{}) (interface{}, bool) {
+	for _, s := range p.steps {
+		if s.isIndex {
+			items, ok := value.([]interface{})
+			if !ok || s.index >= len(items) {
+				return nil, false
+			}
+			value = items[s.index]
+			continue
+		}
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = fields[s.key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Replace returns the value with the selected value replaced by the fn result. Arrays and objects along the path
+// are copied, so the value itself is not modified. false means there is no such value
+func (p Path) Replace(value interface{}, fn func(interface{}) interface{}) (interface{}, bool) {
+	return replace(value, p.steps, fn)
+}
+
+func replace(value interface{}, steps []step, fn func(interface{}) interface{}) (interface{}, bool) {
+	if len(steps) == 0 {
+		return fn(value), true
+	}
+	s := steps[0]
+	if s.isIndex {
+		items, ok := value.([]interface{})
+		if !ok || s.index >= len(items) {
+			return value, false
+		}
+		item, ok := replace(items[s.index], steps[1:], fn)
+		if !ok {
+			return value, false
+		}
+		res := make([]interface{}, len(items))
+		copy(res, items)
+		res[s.index] = item
+		return res, true
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return value, false
+	}
+	field, ok := fields[s.key]
+	if !ok {
+		return value, false
+	}
+	if field, ok = replace(field, steps[1:], fn); !ok {
+		return value, false
+	}
+	res := make(map[string]interface{}, len(fields))
+	for key := range fields {
+		res[key] = fields[key]
+	}
+	res[s.key] = field
+	return res, true
+}
+
+// Exists reports whether the path selects a value
+func (p Path) Exists(value interface{}) bool {
+	_, ok := p.Get(value)
+	return ok
+}
+
+func (p Path) String() string {
+	return p.text
+}
@@ -0,0 +1,58 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathGet(t *testing.T) {
+	var value interface{}
+	require.NoError(t, json.Unmarshal([]byte(`[{"Message": {"To": "f01", "Params": null}}, {"Cid": {"/": "bafy"}}]`), &value))
+
+	for text, expected := range map[string]interface{}{
+		"$":                   value,
+		"$[0].Message.To":     "f01",
+		"$[0]['Message'].To":  "f01",
+		`$[1]["Cid"]['/']`:    "bafy",
+		"$[0].Message.Params": nil,
+	} {
+		res, ok := MustParse(text).Get(value)
+		require.True(t, ok, text)
+		require.Equal(t, expected, res, text)
+	}
+	for _, text := range []string{"$[2]", "$[0].Message.From", "$.Message", "$[0][0]", "$[0].Message.To.Value"} {
+		require.False(t, MustParse(text).Exists(value), text)
+	}
+}
+
+func TestPathReplace(t *testing.T) {
+	var value interface{}
+	require.NoError(t, json.Unmarshal([]byte(`[{"Message": {"To": "t01"}}, 1]`), &value))
+	upper := func(v interface{}) interface{} {
+		return strings.ToUpper(v.(string))
+	}
+	get := func(text string, v interface{}) interface{} {
+		res, _ := MustParse(text).Get(v)
+		return res
+	}
+
+	res, ok := MustParse("$[0].Message.To").Replace(value, upper)
+	require.True(t, ok)
+	require.Equal(t, "T01", get("$[0].Message.To", res))
+	require.Equal(t, "t01", get("$[0].Message.To", value))
+	require.Equal(t, float64(1), get("$[1]", res))
+
+	res, ok = MustParse("$[0].Message.From").Replace(value, upper)
+	require.False(t, ok)
+	require.Equal(t, value, res)
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, text := range []string{"", "Message", "$.", "$..To", "$[", "$[-1]", "$[a]", "$['To'", "$['To'x", "$x"} {
+		_, err := Parse(text)
+		require.Error(t, err, text)
+	}
+}
@@ -0,0 +1,110 @@
+package matcher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/chain"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+)
+
+// ParamsList returns params of the method requests. Placeholders of params generators are replaced by
+// every combination of generated values
+func (c customMethod) ParamsList(head chain.HeadProvider) ([]interface{}, error) {
+	if len(c.generators) == 0 {
+		return []interface{}{c.Params}, nil
+	}
+	names := make([]string, 0, len(c.generators))
+	for name := range c.generators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	combinations := []map[string]interface{}{{}}
+	for _, name := range names {
+		values, err := generate(c.generators[name], head)
+		if err != nil {
+			return nil, fmt.Errorf("params generator %s for method %s: %w", name, c.Name, err)
+		}
+		next := make([]map[string]interface{}, 0, len(combinations)*len(values))
+		for _, combination := range combinations {
+			for _, value := range values {
+				values := make(map[string]interface{}, len(combination)+1)
+				for key, v := range combination {
+					values[key] = v
+				}
+				values[name] = value
+				next = append(next, values)
+			}
+		}
+		combinations = next
+	}
+	res := make([]interface{}, len(combinations))
+	for idx, values := range combinations {
+		res[idx] = substitute(c.Params, values)
+	}
+	return res, nil
+}
+
+func generate(g config.ParamsGenerator, head chain.HeadProvider) ([]interface{}, error) {
+	switch g.Kind {
+	case config.HeadHeightGenerator:
+		if head == nil {
+			return nil, fmt.Errorf("chain head is not available")
+		}
+		count := g.Count
+		if count == 0 {
+			count = 1
+		}
+		var values []interface{}
+		for height := head.Height() - int64(g.Offset); height >= 0 && len(values) < count; height-- {
+			// numbers of parsed requests are float64, so cache keys of generated requests are the same
+			values = append(values, float64(height))
+		}
+		return values, nil
+	case config.HeadKeyGenerator:
+		if head == nil {
+			return nil, fmt.Errorf("chain head is not available")
+		}
+		h, ok := head.Head()
+		if !ok {
+			return nil, fmt.Errorf("chain head is not observed yet")
+		}
+		return []interface{}{h.Key}, nil
+	case config.ListGenerator:
+		return g.Values, nil
+	default:
+		return nil, g.Kind.Valid()
+	}
+}
+
+// substitute replaces "${name}" values within params
+func substitute(params interface{}, values map[string]interface{}) interface{} {
+	switch value := params.(type) {
+	case string:
+		if strings.HasPrefix(value, "${") && strings.HasSuffix(value, "}") {
+			if v, ok := values[value[2:len(value)-1]]; ok {
+				return v
+			}
+		}
+	case []interface{}:
+		res := make([]interface{}, len(value))
+		for idx := range value {
+			res[idx] = substitute(value[idx], values)
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(value))
+		for key := range value {
+			res[key] = substitute(value[key], values)
+		}
+		return res
+	case map[interface{}]interface{}:
+		res := make(map[interface{}]interface{}, len(value))
+		for key := range value {
+			res[key] = substitute(value[key], values)
+		}
+		return res
+	}
+	return params
+}
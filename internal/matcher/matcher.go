@@ -4,10 +4,18 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"path"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/protofire/filecoin-rpc-proxy/internal/chain"
+	"github.com/protofire/filecoin-rpc-proxy/internal/jsonpath"
 	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/config"
 )
@@ -17,6 +25,8 @@ type methods map[string]cacheMethods
 
 type cacheKey struct {
 	Key         string
+	TTL         time.Duration
+	MaxStale    time.Duration
 	cardinality int
 }
 
@@ -33,8 +43,9 @@ func (k cacheKeys) sort() {
 }
 
 type customMethod struct {
-	Name   string
-	Params interface{}
+	Name       string
+	Params     interface{}
+	generators map[string]config.ParamsGenerator
 }
 type customMethods []customMethod
 
@@ -44,8 +55,9 @@ func (m methods) Custom() customMethods {
 		for _, method := range cMethods {
 			if method.kind.IsCustom() {
 				res = append(res, customMethod{
-					Name:   method.name,
-					Params: method.paramsForRequest,
+					Name:       method.name,
+					Params:     method.paramsForRequest,
+					generators: method.paramsGenerators,
 				})
 			}
 		}
@@ -55,9 +67,15 @@ func (m methods) Custom() customMethods {
 
 type Matcher interface {
 	Keys(method string, params interface{}) cacheKeys
+	ResponseKeys(method string, params interface{}, response requests.RPCResponse) cacheKeys
 	Methods() customMethods
 	IsUpdatable(method string) bool
 	IsCacheable(method string) bool
+	IsFinalized(method string, params interface{}) bool
+	IsStaleWhileRevalidate(method string) bool
+	IsStaleIfError(method string) bool
+	NewHeadMethods() []string
+	IsHeadRelative(method string, params interface{}) bool
 }
 
 type cacheMethod struct {
@@ -68,7 +86,116 @@ type cacheMethod struct {
 	noUpdateCache     bool
 	paramsInCacheID   []int
 	paramsInCacheName []string
+	paramsInCachePath []jsonpath.Path
 	paramsForRequest  interface{}
+	paramsGenerators  map[string]config.ParamsGenerator
+	normalizers       []paramNormalizer
+	skipWhen          []skipCondition
+	ttl               time.Duration
+	maxStale          time.Duration
+	epochParamID      int
+	unfinalizedTTL    time.Duration
+	invalidateOn      config.InvalidationEvent
+	// nil means the last param
+	headParam            *jsonpath.Path
+	staleWhileRevalidate bool
+	staleIfError         bool
+	notEmpty             bool
+	pathExists           *jsonpath.Path
+	maxSize              int
+	cacheErrors          map[int]struct{}
+	errorTTL             time.Duration
+	finality             int64
+	head                 chain.HeightProvider
+}
+
+// isHeadRelative reports whether the request of the method invalidated on a new head addresses the chain head,
+// i.e. its TipSetKey param is null, missing or empty
+func (c cacheMethod) isHeadRelative(params interface{}) bool {
+	if !c.invalidateOn.IsNewHead() {
+		return false
+	}
+	params = jsonValue(params)
+	if c.headParam != nil {
+		param, ok := c.headParam.Get(params)
+		return !ok || isEmptyValue(param)
+	}
+	list, ok := params.([]interface{})
+	return !ok || len(list) == 0 || isEmptyValue(list[len(list)-1])
+}
+
+func (c cacheMethod) hasEpoch() bool {
+	return c.epochParamID >= 0 && c.head != nil
+}
+
+func (c cacheMethod) epoch(params interface{}) (int64, error) {
+	sliceParams, ok := params.([]interface{})
+	if !ok || c.epochParamID >= len(sliceParams) {
+		return 0, fmt.Errorf("cannot find epoch parameter %d in params: %v", c.epochParamID, params)
+	}
+	return chain.ParseEpoch(sliceParams[c.epochParamID])
+}
+
+// isFinalized reports whether the epoch parameter is behind the chain finality. Epochs are not finalized
+// until the chain head is observed, since the estimated height is ahead of a lagging node
+func (c cacheMethod) isFinalized(params interface{}) (bool, error) {
+	epoch, err := c.epoch(params)
+	if err != nil {
+		return false, err
+	}
+	height, ok := observedHeight(c.head)
+	if !ok {
+		return false, nil
+	}
+	return epoch <= height-c.finality, nil
+}
+
+// observedHeight returns the height of the observed chain head. false means the head is not observed yet
+// or the provider only estimates the height
+func observedHeight(head chain.HeightProvider) (int64, bool) {
+	provider, ok := head.(chain.HeadProvider)
+	if !ok {
+		return 0, false
+	}
+	h, ok := provider.Head()
+	return h.Height, ok
+}
+
+// isCacheableResponse reports whether the response matches cache conditions of the method.
+// Error responses are cached only with the configured error codes
+func (c cacheMethod) isCacheableResponse(response requests.RPCResponse) bool {
+	if response.Error != nil {
+		_, ok := c.cacheErrors[response.Error.Code]
+		return ok
+	}
+	if c.notEmpty && isEmptyValue(response.Result) {
+		return false
+	}
+	if c.pathExists != nil && !c.pathExists.Exists(response.Result) {
+		return false
+	}
+	if c.maxSize > 0 {
+		result, err := json.Marshal(response.Result)
+		if err != nil || len(result) > c.maxSize {
+			return false
+		}
+	}
+	return true
+}
+
+// isEmptyValue reports whether the value is null or empty string, array or object
+func isEmptyValue(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case []interface{}:
+		return len(value) == 0
+	case map[string]interface{}:
+		return len(value) == 0
+	}
+	return false
 }
 
 func (c cacheMethod) match(params interface{}) ([]interface{}, error) {
@@ -76,7 +203,7 @@ func (c cacheMethod) match(params interface{}) ([]interface{}, error) {
 		return nil, nil
 	}
 	var paramsForCache []interface{}
-	if len(c.paramsInCacheID) == 0 && len(c.paramsInCacheName) == 0 {
+	if len(c.paramsInCacheID) == 0 && len(c.paramsInCacheName) == 0 && len(c.paramsInCachePath) == 0 {
 		// cache by all Params
 		paramsForCache = append(paramsForCache, params)
 		return paramsForCache, nil
@@ -84,7 +211,7 @@ func (c cacheMethod) match(params interface{}) ([]interface{}, error) {
 	if len(c.paramsInCacheID) > 0 {
 		sliceParams, ok := params.([]interface{})
 		if ok {
-			for idx := range c.paramsInCacheID {
+			for _, idx := range c.paramsInCacheID {
 				if idx >= len(sliceParams) {
 					return nil, fmt.Errorf("invalid index %d in slice params: %v", idx, sliceParams)
 				}
@@ -106,34 +233,143 @@ func (c cacheMethod) match(params interface{}) ([]interface{}, error) {
 			return paramsForCache, nil
 		}
 	}
+	if len(c.paramsInCachePath) > 0 {
+		for _, path := range c.paramsInCachePath {
+			param, ok := path.Get(params)
+			if !ok {
+				return nil, fmt.Errorf("cannot find parameter %s in params: %v", path, params)
+			}
+			paramsForCache = append(paramsForCache, param)
+		}
+		return paramsForCache, nil
+	}
 	return nil, fmt.Errorf("cannot match parameters: %v. matcher: %v", params, c)
 }
 
 func (c cacheMethod) toKey(method string, params interface{}) cacheKey {
-	key, err := c.match(params)
+	if c.isSkipped(params) {
+		return cacheKey{}
+	}
+	key, err := c.match(c.normalizeParams(params))
 	if err != nil {
 		logger.Log.Error(err)
 		return cacheKey{}
 	}
+	ttl, maxStale := c.ttl, c.maxStale
+	if c.hasEpoch() {
+		finalized, err := c.isFinalized(params)
+		if err != nil {
+			logger.Log.Error(err)
+			return cacheKey{}
+		}
+		switch {
+		case finalized:
+			// finalized tipsets cannot change anymore
+			ttl, maxStale = 0, 0
+		case c.unfinalizedTTL > 0:
+			ttl, maxStale = c.unfinalizedTTL, 0
+		default:
+			return cacheKey{}
+		}
+	}
 	strKey := interfaceSliceToString(key)
 	keyParams := []string{method}
 	if strKey != "" {
 		keyParams = append(keyParams, strKey)
 	}
-	return cacheKey{Key: strings.Join(keyParams, "_"), cardinality: len(key)}
+	return cacheKey{
+		Key:         strings.Join(keyParams, "_"),
+		TTL:         ttl,
+		MaxStale:    maxStale,
+		cardinality: len(key),
+	}
 }
 
+// methodPattern holds rules of the glob or regular expression method name
+type methodPattern struct {
+	pattern string
+	re      *regexp.Regexp
+	methods cacheMethods
+}
+
+func (p methodPattern) match(method string) bool {
+	if p.re != nil {
+		return p.re.MatchString(method)
+	}
+	ok, _ := path.Match(p.pattern, method)
+	return ok
+}
+
+// maxResolvedMethods limits remembered pattern lookups
+const maxResolvedMethods = 10000
+
 type match struct {
 	methods methods
+	// patterns are matched in configuration order if there is no rule for the exact method name
+	patterns []*methodPattern
+	head     chain.HeightProvider
+	finality int64
+	// method name => resolvedMethods, so patterns are matched once per method
+	resolved *sync.Map
+	// number of resolved methods. method names come from requests, so the number is limited
+	resolvedCount *int64
+}
+
+type resolvedMethods struct {
+	methods cacheMethods
+	ok      bool
+}
+
+// lookup returns rules of the method. Exact names take precedence over patterns
+func (m match) lookup(method string) (cacheMethods, bool) {
+	if methods, ok := m.methods[method]; ok {
+		return methods, true
+	}
+	if len(m.patterns) == 0 {
+		return nil, false
+	}
+	if resolved, ok := m.resolved.Load(method); ok {
+		r := resolved.(resolvedMethods)
+		return r.methods, r.ok
+	}
+	r := resolvedMethods{}
+	for _, p := range m.patterns {
+		if p.match(method) {
+			r = resolvedMethods{methods: p.methods, ok: true}
+			break
+		}
+	}
+	// methods without rules are remembered as well, so misses do not match every pattern again
+	if atomic.AddInt64(m.resolvedCount, 1) <= maxResolvedMethods {
+		m.resolved.Store(method, r)
+	}
+	return r.methods, r.ok
 }
 
 func newMatcher() *match {
 	userMethods := make(methods)
-	return &match{methods: userMethods}
+	return &match{methods: userMethods, resolved: &sync.Map{}, resolvedCount: new(int64)}
+}
+
+// IsFinalized reports whether the request addresses a finalized epoch, so its response never changes
+func (m *match) IsFinalized(method string, params interface{}) bool {
+	methods, ok := m.lookup(method)
+	if !ok {
+		return false
+	}
+	for _, m := range methods {
+		if !m.hasEpoch() {
+			continue
+		}
+		if finalized, err := m.isFinalized(params); err == nil && finalized {
+			return true
+		}
+	}
+	return false
 }
 
 func (m *match) IsUpdatable(method string) bool {
-	methods, ok := m.methods[method]
+	methods, ok := m.lookup(method)
 	if !ok {
 		return false
 	}
@@ -146,7 +382,7 @@ func (m *match) IsUpdatable(method string) bool {
 }
 
 func (m *match) IsCacheable(method string) bool {
-	methods, ok := m.methods[method]
+	methods, ok := m.lookup(method)
 	if !ok {
 		return false
 	}
@@ -158,28 +394,140 @@ func (m *match) IsCacheable(method string) bool {
 	return true
 }
 
-func (m match) addMethod(method config.CacheMethod) {
+// IsStaleWhileRevalidate reports whether stale responses of the method are served while they are refreshed
+func (m *match) IsStaleWhileRevalidate(method string) bool {
+	methods, _ := m.lookup(method)
+	for _, m := range methods {
+		if m.staleWhileRevalidate {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStaleIfError reports whether stale responses of the method are served if the upstream fails
+func (m *match) IsStaleIfError(method string) bool {
+	methods, _ := m.lookup(method)
+	for _, m := range methods {
+		if m.staleIfError {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *match) addMethod(method config.CacheMethod) {
 	if !method.Enabled {
 		return
 	}
 	paramsInCacheName := method.ParamsInCacheByName
 	sort.Strings(paramsInCacheName)
-	m.methods[method.Name] = append(m.methods[method.Name], cacheMethod{
-		kind:              *method.Kind,
-		name:              method.Name,
-		cacheByParams:     method.CacheByParams,
-		paramsInCacheID:   method.ParamsInCacheByID,
-		paramsInCacheName: paramsInCacheName,
-		noStoreCache:      method.NoStoreCache,
-		noUpdateCache:     method.NoUpdateCache,
-		paramsForRequest:  method.ParamsForRequest,
-	})
+	epochParamID := -1
+	if method.EpochParamByID != nil {
+		epochParamID = *method.EpochParamByID
+	}
+	paramsInCachePath := make([]jsonpath.Path, len(method.ParamsInCacheByPath))
+	for idx, path := range method.ParamsInCacheByPath {
+		paramsInCachePath[idx] = jsonpath.MustParse(path)
+	}
+	skipWhen := make([]skipCondition, 0, len(method.SkipWhen))
+	for _, c := range method.SkipWhen {
+		condition, err := newSkipCondition(c)
+		if err != nil {
+			logger.Log.Error(err)
+			continue
+		}
+		skipWhen = append(skipWhen, condition)
+	}
+	normalizers := make([]paramNormalizer, 0, len(method.NormalizeParams))
+	for _, n := range method.NormalizeParams {
+		normalizer, err := newParamNormalizer(n)
+		if err != nil {
+			logger.Log.Error(err)
+			continue
+		}
+		normalizers = append(normalizers, normalizer)
+	}
+	var headParam *jsonpath.Path
+	if method.HeadParam != "" {
+		path := jsonpath.MustParse(method.HeadParam)
+		headParam = &path
+	}
+	var pathExists *jsonpath.Path
+	if method.CacheIf.PathExists != "" {
+		path := jsonpath.MustParse(method.CacheIf.PathExists)
+		pathExists = &path
+	}
+	cacheErrors := make(map[int]struct{}, len(method.CacheErrors))
+	for _, code := range method.CacheErrors {
+		cacheErrors[code] = struct{}{}
+	}
+	cm := cacheMethod{
+		kind:                 *method.Kind,
+		name:                 method.Name,
+		cacheByParams:        method.CacheByParams,
+		paramsInCacheID:      method.ParamsInCacheByID,
+		paramsInCacheName:    paramsInCacheName,
+		paramsInCachePath:    paramsInCachePath,
+		noStoreCache:         method.NoStoreCache,
+		noUpdateCache:        method.NoUpdateCache,
+		paramsForRequest:     method.ParamsForRequest,
+		paramsGenerators:     method.ParamsGenerators,
+		normalizers:          normalizers,
+		skipWhen:             skipWhen,
+		ttl:                  time.Duration(method.TTL) * time.Second,
+		maxStale:             time.Duration(method.MaxStale) * time.Second,
+		epochParamID:         epochParamID,
+		unfinalizedTTL:       time.Duration(method.UnfinalizedTTL) * time.Second,
+		invalidateOn:         method.InvalidateOn,
+		headParam:            headParam,
+		staleWhileRevalidate: method.StaleWhileRevalidate,
+		staleIfError:         method.StaleIfError,
+		notEmpty:             method.CacheIf.NotEmpty,
+		pathExists:           pathExists,
+		maxSize:              method.CacheIf.MaxSize,
+		cacheErrors:          cacheErrors,
+		errorTTL:             time.Duration(method.ErrorTTL) * time.Second,
+		finality:             m.finality,
+		head:                 m.head,
+	}
+	// rules are resolved again with the new method
+	m.resolved, m.resolvedCount = &sync.Map{}, new(int64)
+	if !method.IsPattern() {
+		m.methods[method.Name] = append(m.methods[method.Name], cm)
+		return
+	}
+	for _, p := range m.patterns {
+		if p.pattern == method.Name {
+			p.methods = append(p.methods, cm)
+			return
+		}
+	}
+	p := &methodPattern{pattern: method.Name, methods: cacheMethods{cm}}
+	if method.IsRegexp() {
+		re, err := method.Regexp()
+		if err != nil {
+			logger.Log.Error(err)
+			return
+		}
+		p.re = re
+	}
+	m.patterns = append(m.patterns, p)
 }
 
-// FromConfig init match from config
+// FromConfig init match from config. Chain head height is estimated by the chain clock,
+// so epochs are never finalized
 // nolint
 func FromConfig(c *config.Config) *match {
+	return FromConfigWithHead(c, chain.NewClock(c.Chain.GenesisTimestamp, c.Chain.BlockDelay))
+}
+
+// FromConfigWithHead init match from config with the chain head provider
+// nolint
+func FromConfigWithHead(c *config.Config, head chain.HeightProvider) *match {
 	matcher := newMatcher()
+	matcher.head = head
+	matcher.finality = int64(c.Chain.Finality)
 	for _, method := range c.CacheMethods {
 		matcher.addMethod(method)
 	}
@@ -187,7 +535,7 @@ func FromConfig(c *config.Config) *match {
 }
 
 func (m match) Keys(method string, params interface{}) cacheKeys {
-	cacheMethods, ok := m.methods[method]
+	cacheMethods, ok := m.lookup(method)
 	if !ok {
 		return nil
 	}
@@ -203,6 +551,68 @@ func (m match) Keys(method string, params interface{}) cacheKeys {
 	return keys
 }
 
+// ResponseKeys returns keys to cache the response by. Methods skip responses which do not match their conditions,
+// error responses are cached for error_ttl
+func (m match) ResponseKeys(method string, params interface{}, response requests.RPCResponse) cacheKeys {
+	var keys cacheKeys
+	cacheMethods, _ := m.lookup(method)
+	for _, cm := range cacheMethods {
+		if !cm.isCacheableResponse(response) {
+			continue
+		}
+		key := cm.toKey(method, params)
+		if key.IsEmpty() {
+			continue
+		}
+		if response.Error != nil {
+			key.TTL, key.MaxStale = cm.errorTTL, 0
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) > 0 {
+		keys.sort()
+	}
+	return keys
+}
+
+// NewHeadMethods returns methods and method patterns whose cached responses are invalidated on a new chain head
+func (m match) NewHeadMethods() []string {
+	var res []string
+	for name, cMethods := range m.methods {
+		if cMethods.invalidatedOnNewHead() {
+			res = append(res, name)
+		}
+	}
+	for _, p := range m.patterns {
+		if p.methods.invalidatedOnNewHead() {
+			res = append(res, p.pattern)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// IsHeadRelative reports whether the cached response of the request is invalidated on a new chain head.
+// Only requests addressing the chain head are, responses at explicit tipsets never change
+func (m match) IsHeadRelative(method string, params interface{}) bool {
+	methods, _ := m.lookup(method)
+	for _, cm := range methods {
+		if cm.isHeadRelative(params) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c cacheMethods) invalidatedOnNewHead() bool {
+	for _, method := range c {
+		if method.invalidateOn.IsNewHead() {
+			return true
+		}
+	}
+	return false
+}
+
 func (m match) Methods() customMethods {
 	return m.methods.Custom()
 }
@@ -1,11 +1,17 @@
 package matcher
 
 import (
+	"encoding/json"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/protofire/filecoin-rpc-proxy/internal/chain"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/jsonpath"
 	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
 	"go.uber.org/goleak"
 
 	"github.com/stretchr/testify/require"
@@ -47,6 +53,37 @@ func TestMatcherCacheParamsByID(t *testing.T) {
 	require.Len(t, parts, 2)
 }
 
+func TestMatcherCacheParamsByIDValues(t *testing.T) {
+	matcherImp := newMatcher()
+	matcherImp.methods[testMethod] = append(matcherImp.methods[testMethod], cacheMethod{
+		cacheByParams:   true,
+		paramsInCacheID: []int{2},
+	})
+	matcherImp.methods["other"] = append(matcherImp.methods["other"], cacheMethod{
+		cacheByParams:   true,
+		paramsInCacheID: []int{0},
+	})
+	keys := matcherImp.Keys(testMethod, []interface{}{"1", "2", "3"})
+	require.Len(t, keys, 1)
+	require.Equal(t, strings.Split(matcherImp.Keys("other", []interface{}{"3"})[0].Key, "_")[1], strings.Split(keys[0].Key, "_")[1])
+	require.Len(t, matcherImp.Keys(testMethod, []interface{}{"1", "2"}), 0)
+}
+
+func TestMatcherCacheParamsByPath(t *testing.T) {
+	matcherImp := newMatcher()
+	matcherImp.methods[testMethod] = append(matcherImp.methods[testMethod], cacheMethod{
+		cacheByParams:     true,
+		paramsInCachePath: []jsonpath.Path{jsonpath.MustParse("$[0].To"), jsonpath.MustParse("$[0].Method")},
+	})
+	message := func(nonce float64) []interface{} {
+		return []interface{}{map[string]interface{}{"To": "f01", "Method": float64(2), "Nonce": nonce}, nil}
+	}
+	keys := matcherImp.Keys(testMethod, message(1))
+	require.Len(t, keys, 1)
+	require.Equal(t, keys, matcherImp.Keys(testMethod, message(2)))
+	require.Len(t, matcherImp.Keys(testMethod, []interface{}{map[string]interface{}{"To": "f01"}}), 0)
+}
+
 func TestMatcherCacheParamsByName(t *testing.T) {
 	matcherImp := newMatcher()
 	matcherImp.methods[testMethod] = append(matcherImp.methods[testMethod], cacheMethod{
@@ -99,6 +136,321 @@ func TestMatcherCacheParamsByNameAndByID(t *testing.T) {
 	require.Len(t, parts, 2)
 }
 
+func TestMatcherKeysTTL(t *testing.T) {
+	matcherImp := newMatcher()
+	matcherImp.methods[testMethod] = append(matcherImp.methods[testMethod], cacheMethod{
+		cacheByParams: true,
+		ttl:           time.Minute,
+		maxStale:      time.Second,
+	})
+	keys := matcherImp.Keys(testMethod, []interface{}{"1"})
+	require.Len(t, keys, 1)
+	require.Equal(t, time.Minute, keys[0].TTL)
+	require.Equal(t, time.Second, keys[0].MaxStale)
+}
+
+type testHead int64
+
+func (h testHead) Height() int64 {
+	return int64(h)
+}
+
+func (h testHead) Head() (chain.Head, bool) {
+	return chain.Head{Height: int64(h), Key: []interface{}{map[string]interface{}{"/": "bafy"}}}, true
+}
+
+func TestMatcherFinalizedEpoch(t *testing.T) {
+	matcherImp := newMatcher()
+	matcherImp.methods[testMethod] = append(matcherImp.methods[testMethod], cacheMethod{
+		cacheByParams:   true,
+		paramsInCacheID: []int{0},
+		ttl:             time.Minute,
+		epochParamID:    0,
+		finality:        900,
+		head:            testHead(2000),
+	})
+	keys := matcherImp.Keys(testMethod, []interface{}{float64(1000), nil})
+	require.Len(t, keys, 1)
+	require.Equal(t, time.Duration(0), keys[0].TTL)
+	require.True(t, matcherImp.IsFinalized(testMethod, []interface{}{float64(1000), nil}))
+
+	keys = matcherImp.Keys(testMethod, []interface{}{float64(1500), nil})
+	require.Len(t, keys, 0)
+	require.False(t, matcherImp.IsFinalized(testMethod, []interface{}{float64(1500), nil}))
+
+	// the estimated height is not trusted until the chain head is observed
+	for _, head := range []chain.HeightProvider{
+		chain.NewClock(0, 30),
+		chain.NewTracker(chain.NewClock(0, 30), logger.Log, "http://test.com", "token", false, false),
+	} {
+		matcherImp.methods[testMethod][0].head = head
+		require.Len(t, matcherImp.Keys(testMethod, []interface{}{float64(1000), nil}), 0)
+		require.False(t, matcherImp.IsFinalized(testMethod, []interface{}{float64(1000), nil}))
+	}
+}
+
+func TestMatcherUnfinalizedEpoch(t *testing.T) {
+	matcherImp := newMatcher()
+	matcherImp.methods[testMethod] = append(matcherImp.methods[testMethod], cacheMethod{
+		cacheByParams:   true,
+		paramsInCacheID: []int{0},
+		epochParamID:    0,
+		unfinalizedTTL:  time.Second,
+		finality:        900,
+		head:            testHead(2000),
+	})
+	keys := matcherImp.Keys(testMethod, []interface{}{"1500", nil})
+	require.Len(t, keys, 1)
+	require.Equal(t, time.Second, keys[0].TTL)
+}
+
+func TestMatcherResponseKeys(t *testing.T) {
+	var response requests.RPCResponse
+	require.NoError(t, json.Unmarshal([]byte(`{"jsonrpc": "2.0", "id": 1, "result": {"Receipt": {"ExitCode": 0}}}`), &response))
+	var errResponse requests.RPCResponse
+	require.NoError(t, json.Unmarshal([]byte(`{"jsonrpc": "2.0", "id": 1, "error": {"code": 1, "message": "not found"}}`), &errResponse))
+	receipt := jsonpath.MustParse("$.Receipt")
+	matcherImp := newMatcher()
+	matcherImp.methods[testMethod] = append(matcherImp.methods[testMethod], cacheMethod{
+		ttl:         time.Minute,
+		maxStale:    time.Minute,
+		notEmpty:    true,
+		pathExists:  &receipt,
+		maxSize:     100,
+		cacheErrors: map[int]struct{}{1: {}},
+		errorTTL:    time.Second,
+	})
+
+	keys := matcherImp.ResponseKeys(testMethod, nil, response)
+	require.Len(t, keys, 1)
+	require.Equal(t, time.Minute, keys[0].TTL)
+	require.Equal(t, time.Minute, keys[0].MaxStale)
+
+	keys = matcherImp.ResponseKeys(testMethod, nil, errResponse)
+	require.Len(t, keys, 1)
+	require.Equal(t, time.Second, keys[0].TTL)
+	require.Equal(t, time.Duration(0), keys[0].MaxStale)
+
+	errResponse.Error.Code = 2
+	require.Len(t, matcherImp.ResponseKeys(testMethod, nil, errResponse), 0)
+	for _, result := range []interface{}{nil, "", []interface{}{}, map[string]interface{}{}} {
+		require.Len(t, matcherImp.ResponseKeys(testMethod, nil, requests.RPCResponse{Result: result}), 0)
+	}
+	response.Result = map[string]interface{}{"Message": "bafy"}
+	require.Len(t, matcherImp.ResponseKeys(testMethod, nil, response), 0)
+	response.Result = map[string]interface{}{"Receipt": strings.Repeat("0", 100)}
+	require.Len(t, matcherImp.ResponseKeys(testMethod, nil, response), 0)
+}
+
+func TestMatcherNewHeadMethods(t *testing.T) {
+	matcherImp := newMatcher()
+	matcherImp.methods[testMethod] = append(matcherImp.methods[testMethod], cacheMethod{
+		invalidateOn: config.NewHeadInvalidation,
+	})
+	matcherImp.methods["other"] = append(matcherImp.methods["other"], cacheMethod{})
+	require.Equal(t, []string{testMethod}, matcherImp.NewHeadMethods())
+}
+
+func TestCustomMethodParamsList(t *testing.T) {
+	method := customMethod{
+		Name:   testMethod,
+		Params: []interface{}{"${height}", "${key}", map[interface{}]interface{}{"miner": "${miner}"}, "${other}"},
+		generators: map[string]config.ParamsGenerator{
+			"height": {Kind: config.HeadHeightGenerator, Offset: 1, Count: 2},
+			"key":    {Kind: config.HeadKeyGenerator},
+			"miner":  {Kind: config.ListGenerator, Values: []interface{}{"f01", "f02"}},
+		},
+	}
+	paramsList, err := method.ParamsList(testHead(100))
+	require.NoError(t, err)
+	key := []interface{}{map[string]interface{}{"/": "bafy"}}
+	miner := func(m string) map[interface{}]interface{} {
+		return map[interface{}]interface{}{"miner": m}
+	}
+	require.Equal(t, []interface{}{
+		[]interface{}{float64(99), key, miner("f01"), "${other}"},
+		[]interface{}{float64(99), key, miner("f02"), "${other}"},
+		[]interface{}{float64(98), key, miner("f01"), "${other}"},
+		[]interface{}{float64(98), key, miner("f02"), "${other}"},
+	}, paramsList)
+	require.Equal(t, []interface{}{"${height}", "${key}", miner("${miner}"), "${other}"}, method.Params)
+
+	_, err = method.ParamsList(nil)
+	require.Error(t, err)
+
+	paramsList, err = customMethod{Name: testMethod, Params: []interface{}{}}.ParamsList(nil)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{[]interface{}{}}, paramsList)
+}
+
+func normalizersTestMethod(t *testing.T, params ...config.NormalizeParam) cacheMethod {
+	method := cacheMethod{cacheByParams: true, epochParamID: -1}
+	for _, param := range params {
+		normalizer, err := newParamNormalizer(param)
+		require.NoError(t, err)
+		method.normalizers = append(method.normalizers, normalizer)
+	}
+	return method
+}
+
+func TestNormalizeParams(t *testing.T) {
+	cid := func(c string) map[string]interface{} {
+		return map[string]interface{}{"/": c}
+	}
+	method := normalizersTestMethod(t,
+		config.NormalizeParam{Param: "$[0]", Type: config.AddressParam},
+		config.NormalizeParam{Param: "$[1]", Type: config.BigIntParam},
+		config.NormalizeParam{Param: "$[2]", Type: config.TipSetKeyParam},
+		config.NormalizeParam{Param: "$[3].Cid", Type: config.CIDParam},
+		config.NormalizeParam{Param: "$[4]", Type: config.TipSetKeyParam},
+	)
+	params := []interface{}{
+		"t410f2abc", "-1", []interface{}{cid("bafy2"), cid("BAFY1")},
+		map[string]interface{}{"To": "t01", "Cid": cid("BAFYUP")}, []interface{}{},
+		// params without normalizers are not changed
+		"t01", "123", []interface{}{cid("bafy2"), cid("bafy1")}, []interface{}{},
+	}
+	require.Equal(t,
+		[]interface{}{
+			"f410f2abc", json.Number("-1"), []interface{}{cid("bafy1"), cid("bafy2")},
+			map[string]interface{}{"To": "t01", "Cid": cid("bafyup")}, nil,
+			"t01", "123", []interface{}{cid("bafy2"), cid("bafy1")}, []interface{}{},
+		},
+		method.normalizeParams(params),
+	)
+	require.Equal(t, "t410f2abc", params[0])
+
+	// values which are not of the type are not changed. typed params are normalized too
+	method = normalizersTestMethod(t,
+		config.NormalizeParam{Param: "$[0]", Type: config.BigIntParam},
+		config.NormalizeParam{Param: "$[1]", Type: config.BigIntParam},
+		config.NormalizeParam{Param: "$[2]", Type: config.TipSetKeyParam},
+	)
+	require.Equal(t, []interface{}{"0123", json.Number("1")}, method.normalizeParams([]string{"0123", "1"}))
+	require.Equal(t,
+		[]interface{}{json.Number("1"), float64(1), []interface{}{"bafy2", cid("bafy1")}},
+		method.normalizeParams([]interface{}{"1", float64(1), []interface{}{"bafy2", cid("bafy1")}}),
+	)
+}
+
+func TestMatcherNormalizedKeys(t *testing.T) {
+	matcherImp := newMatcher()
+	matcherImp.methods[testMethod] = append(matcherImp.methods[testMethod], normalizersTestMethod(t,
+		config.NormalizeParam{Param: "$[0]", Type: config.AddressParam},
+		config.NormalizeParam{Param: "$[1]", Type: config.TipSetKeyParam},
+	))
+	tsk := []interface{}{map[string]interface{}{"/": "bafy2"}, map[string]interface{}{"/": "bafy1"}}
+	params := []interface{}{"t01234", tsk}
+	key := matcherImp.Keys(testMethod, params)[0].Key
+	require.Equal(t, key, matcherImp.Keys(testMethod, []interface{}{"f01234", []interface{}{tsk[1], tsk[0]}})[0].Key)
+	require.Equal(t, "bafy2", tsk[0].(map[string]interface{})["/"])
+	require.Equal(t, "t01234", params[0])
+	require.Equal(t,
+		matcherImp.Keys(testMethod, []interface{}{"f01", nil})[0].Key,
+		matcherImp.Keys(testMethod, []interface{}{"t01", []interface{}{}})[0].Key,
+	)
+
+	matcherImp.methods[testMethod][0].normalizers = nil
+	require.NotEqual(t, key, matcherImp.Keys(testMethod, []interface{}{"f01234", tsk})[0].Key)
+}
+
+func TestMatcherMethodPatterns(t *testing.T) {
+	conf := &config.Config{ProxyURL: "http://test.com", JWTSecret: "secret"}
+	for _, method := range []config.CacheMethod{
+		{Name: "Filecoin.StateMinerInfo", CacheByParams: true, TTL: 1},
+		{Name: "Filecoin.StateMiner*", CacheByParams: true, TTL: 2, InvalidateOn: config.NewHeadInvalidation},
+		{Name: "/^eth_get.*ByNumber$/", CacheByParams: true, TTL: 3},
+		{Name: "eth_get*", CacheByParams: true, TTL: 4},
+	} {
+		method.Enabled = true
+		conf.CacheMethods = append(conf.CacheMethods, method)
+	}
+	conf.Init()
+	require.NoError(t, conf.Validate())
+	matcherImp := FromConfig(conf)
+
+	for method, ttl := range map[string]time.Duration{
+		"Filecoin.StateMinerInfo":  time.Second,
+		"Filecoin.StateMinerPower": 2 * time.Second,
+		"eth_getBlockByNumber":     3 * time.Second,
+		"eth_getBlockByHash":       4 * time.Second,
+	} {
+		keys := matcherImp.Keys(method, []interface{}{"f01"})
+		require.Len(t, keys, 1, method)
+		require.Equal(t, ttl, keys[0].TTL, method)
+		require.True(t, strings.HasPrefix(keys[0].Key, method+"_"), method)
+		require.True(t, matcherImp.IsCacheable(method), method)
+	}
+	require.Len(t, matcherImp.Keys("Filecoin.StateGetActor", nil), 0)
+	require.Len(t, matcherImp.Keys("xeth_getBlockByNumber", nil), 0)
+	require.False(t, matcherImp.IsCacheable("Filecoin.ChainHead"))
+
+	// pattern lookups are remembered, misses as well
+	resolved, ok := matcherImp.resolved.Load("Filecoin.StateMinerPower")
+	require.True(t, ok)
+	require.True(t, resolved.(resolvedMethods).ok)
+	resolved, ok = matcherImp.resolved.Load("Filecoin.ChainHead")
+	require.True(t, ok)
+	require.False(t, resolved.(resolvedMethods).ok)
+	require.False(t, matcherImp.IsCacheable("Filecoin.ChainHead"))
+
+	require.Equal(t, []string{"Filecoin.StateMiner*"}, matcherImp.NewHeadMethods())
+	require.True(t, matcherImp.IsHeadRelative("Filecoin.StateMinerPower", []interface{}{"f01", nil}))
+	require.True(t, matcherImp.IsHeadRelative("Filecoin.StateMinerPower", []interface{}{"f01", []interface{}{}}))
+	require.True(t, matcherImp.IsHeadRelative("Filecoin.StateMinerPower", nil))
+	tsk := []interface{}{map[string]interface{}{"/": "bafy"}}
+	require.False(t, matcherImp.IsHeadRelative("Filecoin.StateMinerPower", []interface{}{"f01", tsk}))
+	require.False(t, matcherImp.IsHeadRelative("Filecoin.StateMinerInfo", []interface{}{"f01", nil}))
+}
+
+func TestMatcherSkipWhen(t *testing.T) {
+	withinHead := 10
+	conditions := []config.SkipCondition{
+		{Param: "$[1]", Empty: true},
+		{Param: "$[0]", Equals: "latest"},
+		{Param: "$[0]", Equals: 5},
+		{Param: "$[0]", WithinHead: &withinHead},
+	}
+	var skipWhen []skipCondition
+	for _, c := range conditions {
+		require.NoError(t, c.Valid())
+		condition, err := newSkipCondition(c)
+		require.NoError(t, err)
+		skipWhen = append(skipWhen, condition)
+	}
+	matcherImp := newMatcher()
+	matcherImp.methods[testMethod] = append(matcherImp.methods[testMethod], cacheMethod{
+		cacheByParams: true,
+		skipWhen:      skipWhen,
+		epochParamID:  -1,
+		head:          testHead(100),
+	})
+	tsk := []interface{}{map[string]interface{}{"/": "bafy"}}
+	for _, params := range [][]interface{}{
+		{"f01", []interface{}{}},
+		{"f01", nil},
+		{"f01"},
+		{"latest", tsk},
+		{float64(5), tsk},
+		{float64(90), tsk},
+		{"95", tsk},
+		// epochs which cannot be parsed
+		{"f01", tsk},
+		{"earliest", tsk},
+	} {
+		require.Len(t, matcherImp.Keys(testMethod, params), 0, params)
+	}
+	for _, params := range [][]interface{}{
+		{float64(89), tsk},
+		{"89", tsk},
+	} {
+		require.Len(t, matcherImp.Keys(testMethod, params), 1, params)
+	}
+
+	matcherImp.methods[testMethod][0].head = nil
+	require.Len(t, matcherImp.Keys(testMethod, []interface{}{float64(89), tsk}), 0)
+}
+
 func TestKeys(t *testing.T) {
 	allKeys := cacheKeys{{Key: "1", cardinality: 1}, {Key: "2", cardinality: 2}, {Key: "3", cardinality: 100}}
 	allKeys.sort()
@@ -0,0 +1,137 @@
+package matcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/jsonpath"
+)
+
+var (
+	// addresses of every protocol, https://spec.filecoin.io/appendix/address/
+	addressRe = regexp.MustCompile(`^[ft]([0-3][0-9a-z]+|4[0-9]+f[0-9a-z]+)$`)
+	integerRe = regexp.MustCompile(`^-?(0|[1-9][0-9]*)$`)
+)
+
+// paramNormalizer canonicalizes the param of the type
+type paramNormalizer struct {
+	param     jsonpath.Path
+	normalize func(interface{}) interface{}
+}
+
+func newParamNormalizer(n config.NormalizeParam) (paramNormalizer, error) {
+	param, err := jsonpath.Parse(n.Param)
+	if err != nil {
+		return paramNormalizer{}, err
+	}
+	normalizer := paramNormalizer{param: param}
+	switch n.Type {
+	case config.TipSetKeyParam:
+		normalizer.normalize = normalizeTipSetKey
+	case config.CIDParam:
+		normalizer.normalize = normalizeCid
+	case config.AddressParam:
+		normalizer.normalize = normalizeAddress
+	case config.BigIntParam:
+		normalizer.normalize = normalizeBigInt
+	default:
+		return paramNormalizer{}, fmt.Errorf("unknown param type: %s", n.Type)
+	}
+	return normalizer, nil
+}
+
+// normalizeParams canonicalizes the configured params, so equivalent params produce the same cache key.
+// Requests are not changed
+func (c cacheMethod) normalizeParams(params interface{}) interface{} {
+	if len(c.normalizers) == 0 {
+		return params
+	}
+	params = jsonValue(params)
+	for _, n := range c.normalizers {
+		params, _ = n.param.Replace(params, n.normalize)
+	}
+	return params
+}
+
+// jsonValue converts typed values into the JSON data model
+func jsonValue(params interface{}) interface{} {
+	switch params.(type) {
+	case nil, bool, float64, string, json.Number, []interface{}, map[string]interface{}:
+		return params
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return params
+	}
+	var res interface{}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return params
+	}
+	return res
+}
+
+// normalizeTipSetKey makes the empty TipSetKey null and sorts CIDs of the TipSetKey
+func normalizeTipSetKey(value interface{}) interface{} {
+	list, ok := value.([]interface{})
+	if !ok {
+		return value
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	cids := make([]string, len(list))
+	for idx, item := range list {
+		cid, ok := cidValue(normalizeCid(item))
+		if !ok {
+			return value
+		}
+		cids[idx] = cid
+	}
+	sort.Strings(cids)
+	res := make([]interface{}, len(cids))
+	for idx, cid := range cids {
+		res[idx] = map[string]interface{}{"/": cid}
+	}
+	return res
+}
+
+// normalizeCid makes multibase base32 upper case CIDs lower case
+func normalizeCid(value interface{}) interface{} {
+	cid, ok := cidValue(value)
+	if !ok || !strings.HasPrefix(cid, "B") {
+		return value
+	}
+	return map[string]interface{}{"/": strings.ToLower(cid)}
+}
+
+// normalizeAddress makes testnet addresses mainnet addresses. Lotus accepts both
+func normalizeAddress(value interface{}) interface{} {
+	address, ok := value.(string)
+	if !ok || !addressRe.MatchString(address) {
+		return value
+	}
+	return "f" + address[1:]
+}
+
+// normalizeBigInt makes integer strings numbers
+func normalizeBigInt(value interface{}) interface{} {
+	number, ok := value.(string)
+	if !ok || !integerRe.MatchString(number) {
+		return value
+	}
+	return json.Number(number)
+}
+
+// cidValue returns the CID of the CID object {"/": "bafy..."}
+func cidValue(value interface{}) (string, bool) {
+	fields, ok := value.(map[string]interface{})
+	if !ok || len(fields) != 1 {
+		return "", false
+	}
+	cid, ok := fields["/"].(string)
+	return cid, ok
+}
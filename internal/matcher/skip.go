@@ -0,0 +1,72 @@
+package matcher
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/chain"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/jsonpath"
+)
+
+// skipCondition matches requests which are not cached
+type skipCondition struct {
+	param  jsonpath.Path
+	empty  bool
+	equals []byte
+	// -1 means the condition is not set
+	withinHead int64
+}
+
+func newSkipCondition(c config.SkipCondition) (skipCondition, error) {
+	param, err := jsonpath.Parse(c.Param)
+	if err != nil {
+		return skipCondition{}, err
+	}
+	condition := skipCondition{param: param, empty: c.Empty, withinHead: -1}
+	if c.Equals != nil {
+		// values are compared as JSON, so numbers of config and requests are the same
+		if condition.equals, err = json.Marshal(c.Equals); err != nil {
+			return skipCondition{}, err
+		}
+	}
+	if c.WithinHead != nil {
+		condition.withinHead = int64(*c.WithinHead)
+	}
+	return condition, nil
+}
+
+func (s skipCondition) matches(params interface{}, head chain.HeightProvider) bool {
+	param, ok := s.param.Get(params)
+	switch {
+	case s.empty:
+		return !ok || isEmptyValue(param)
+	case !ok:
+		return false
+	case s.equals != nil:
+		value, err := json.Marshal(param)
+		return err == nil && bytes.Equal(value, s.equals)
+	case s.withinHead >= 0:
+		if head == nil {
+			// the head is unknown, so the request is not cached to be safe
+			return true
+		}
+		epoch, err := chain.ParseEpoch(param)
+		if err != nil {
+			// the epoch is unknown, so the request is not cached to be safe
+			return true
+		}
+		return epoch >= head.Height()-s.withinHead
+	}
+	return false
+}
+
+// isSkipped reports whether the request matches any skip condition of the method
+func (c cacheMethod) isSkipped(params interface{}) bool {
+	for _, condition := range c.skipWhen {
+		if condition.matches(params, c.head) {
+			return true
+		}
+	}
+	return false
+}
@@ -11,6 +11,26 @@ var (
 		Name:      "cache_size",
 		Help:      "The proxy cache size",
 	})
+	cacheBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "proxy",
+		Name:      "cache_bytes",
+		Help:      "The proxy cache size in bytes",
+	})
+	cacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "cache_evictions",
+		Help:      "The total number of cache entries evicted due to the cache limits",
+	})
+	cacheUncompressedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "cache_uncompressed_bytes",
+		Help:      "The total number of cache value bytes before compression by method",
+	}, labels)
+	cacheCompressedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "cache_compressed_bytes",
+		Help:      "The total number of cache value bytes after compression by method",
+	}, labels)
 	proxyRequestDuration = prometheus.NewSummary(prometheus.SummaryOpts{
 		Namespace: "proxy",
 		Name:      "request_duration",
@@ -36,6 +56,26 @@ var (
 		Name:      "requests_method_cached",
 		Help:      "The total number of cached proxy requests by method",
 	}, labels)
+	staleProxyRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "requests_stale",
+		Help:      "The total number of proxy requests served with stale cached responses",
+	})
+	staleProxyRequestsByMethod = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "requests_method_stale",
+		Help:      "The total number of proxy requests served with stale cached responses by method",
+	}, labels)
+	coalescedProxyRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "requests_coalesced",
+		Help:      "The total number of proxy requests coalesced with the same running upstream request",
+	})
+	coalescedProxyRequestsByMethod = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "requests_method_coalesced",
+		Help:      "The total number of proxy requests coalesced with the same running upstream request by method",
+	}, labels)
 	errorProxyRequests = prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: "proxy",
 		Name:      "requests_error",
@@ -46,6 +86,21 @@ var (
 		Name:      "requests_method_error",
 		Help:      "The total number of failed proxy requests",
 	}, labels)
+	chainHeadHeight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "proxy",
+		Name:      "chain_head_height",
+		Help:      "The tracked chain head height",
+	})
+	chainHeadTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "proxy",
+		Name:      "chain_head_timestamp",
+		Help:      "The tracked chain head timestamp",
+	})
+	chainHeadErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "chain_head_errors",
+		Help:      "The total number of failed chain head requests",
+	})
 )
 
 // SetRequestDuration ...
@@ -58,6 +113,22 @@ func SetCacheSize(n int64) {
 	cacheSize.Set(float64(n))
 }
 
+// SetCacheBytes ...
+func SetCacheBytes(n int64) {
+	cacheBytes.Set(float64(n))
+}
+
+// SetCacheEvictionsCounter ...
+func SetCacheEvictionsCounter(n int) {
+	cacheEvictions.Add(float64(n))
+}
+
+// SetCacheCompressedBytes ...
+func SetCacheCompressedBytes(method string, uncompressed, compressed int) {
+	cacheUncompressedBytes.With(prometheus.Labels{"method": method}).Add(float64(uncompressed))
+	cacheCompressedBytes.With(prometheus.Labels{"method": method}).Add(float64(compressed))
+}
+
 // SetRequestsCounter ...
 func SetRequestsCounter() {
 	proxyRequests.Inc()
@@ -104,13 +175,50 @@ func SetRequestsCachedCounterByMethods(methods ...string) {
 	}
 }
 
+// SetRequestsStaleCounterByMethods ...
+func SetRequestsStaleCounterByMethods(methods ...string) {
+	staleProxyRequests.Add(float64(len(methods)))
+	for _, method := range methods {
+		staleProxyRequestsByMethod.With(prometheus.Labels{"method": method}).Inc()
+	}
+}
+
+// SetRequestsCoalescedCounterByMethod ...
+func SetRequestsCoalescedCounterByMethod(method string) {
+	coalescedProxyRequests.Inc()
+	coalescedProxyRequestsByMethod.With(prometheus.Labels{"method": method}).Inc()
+}
+
+// SetChainHead ...
+func SetChainHead(height, timestamp int64) {
+	chainHeadHeight.Set(float64(height))
+	chainHeadTimestamp.Set(float64(timestamp))
+}
+
+// SetChainHeadErrorsCounter ...
+func SetChainHeadErrorsCounter() {
+	chainHeadErrors.Inc()
+}
+
 // Register ...
 func Register() {
+	prometheus.MustRegister(cacheSize)
+	prometheus.MustRegister(cacheBytes)
+	prometheus.MustRegister(cacheEvictions)
+	prometheus.MustRegister(cacheUncompressedBytes)
+	prometheus.MustRegister(cacheCompressedBytes)
 	prometheus.MustRegister(proxyRequestDuration)
 	prometheus.MustRegister(errorProxyRequests)
 	prometheus.MustRegister(errorProxyRequestsByMethod)
 	prometheus.MustRegister(cachedProxyRequests)
 	prometheus.MustRegister(cachedProxyRequestsByMethod)
+	prometheus.MustRegister(staleProxyRequests)
+	prometheus.MustRegister(staleProxyRequestsByMethod)
+	prometheus.MustRegister(coalescedProxyRequests)
+	prometheus.MustRegister(coalescedProxyRequestsByMethod)
 	prometheus.MustRegister(proxyRequests)
 	prometheus.MustRegister(proxyRequestsByMethod)
+	prometheus.MustRegister(chainHeadHeight)
+	prometheus.MustRegister(chainHeadTimestamp)
+	prometheus.MustRegister(chainHeadErrors)
 }
@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/jwtauth"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+)
+
+const (
+	adminPermission     = "admin"
+	defaultAdminLimit   = 100
+	maxAdminLimit       = 1000
+	adminAllowClaimName = "Allow"
+)
+
+type adminKey struct {
+	Key    string `json:"key"`
+	Method string `json:"method"`
+	Size   int    `json:"size"`
+	Stale  bool   `json:"stale"`
+}
+
+type adminKeys struct {
+	Total  int        `json:"total"`
+	Offset int        `json:"offset"`
+	Limit  int        `json:"limit"`
+	Keys   []adminKey `json:"keys"`
+}
+
+type adminPurged struct {
+	Purged int `json:"purged"`
+}
+
+// AdminRoutes returns cache inspection and purging routes
+func (p *Server) AdminRoutes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(AdminAuthenticator)
+	r.Get("/cache/keys", p.adminListKeys)
+	r.Get("/cache/keys/{key}", p.adminGetKey)
+	r.Delete("/cache/keys/{key}", p.adminPurgeKey)
+	r.Delete("/cache/methods/{method}", p.adminPurgeMethod)
+	r.Delete("/cache", p.adminPurgeAll)
+	r.Get("/cache/stats", p.adminStats)
+	return r
+}
+
+// AdminAuthenticator allows only tokens with admin permission
+func AdminAuthenticator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, claims, err := jwtauth.FromContext(r.Context())
+		if err != nil || !hasPermission(claims[adminAllowClaimName], adminPermission) {
+			data, err := json.Marshal(requests.JSONRPCUnauthenticated())
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			http.Error(w, string(data), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func hasPermission(claim interface{}, permission string) bool {
+	perms, ok := claim.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, perm := range perms {
+		if perm == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Server) adminJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		p.logger.Errorf("response send error %v", err)
+	}
+}
+
+func (p *Server) adminError(w http.ResponseWriter, code int, err error) {
+	p.adminJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func adminQueryInt(r *http.Request, name string, value int) (int, error) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return value, nil
+	}
+	return strconv.Atoi(param)
+}
+
+// adminURLParam returns unescaped url parameter
+func adminURLParam(r *http.Request, name string) (string, error) {
+	return url.PathUnescape(chi.URLParam(r, name))
+}
+
+// adminListKeys lists cached keys sorted by key. Keys are filtered by the method query parameter
+// and paged by the offset and limit query parameters
+func (p *Server) adminListKeys(w http.ResponseWriter, r *http.Request) {
+	offset, err := adminQueryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		p.adminJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid offset"})
+		return
+	}
+	limit, err := adminQueryInt(r, "limit", defaultAdminLimit)
+	if err != nil || limit <= 0 || limit > maxAdminLimit {
+		p.adminJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid limit"})
+		return
+	}
+	entries, err := p.cacher.Cacher().Entries()
+	if err != nil {
+		p.adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	method := r.URL.Query().Get("method")
+	now := time.Now()
+	res := adminKeys{Offset: offset, Limit: limit, Keys: []adminKey{}}
+	keys := make([]adminKey, 0, len(entries))
+	for _, entry := range entries {
+		if method != "" && entry.Request.Method != method {
+			continue
+		}
+		keys = append(keys, adminKey{
+			Key:    entry.Key,
+			Method: entry.Request.Method,
+			Size:   entry.Size,
+			Stale:  entry.IsStale(now),
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Key < keys[j].Key
+	})
+	res.Total = len(keys)
+	if offset < len(keys) {
+		end := offset + limit
+		if end > len(keys) {
+			end = len(keys)
+		}
+		res.Keys = keys[offset:end]
+	}
+	p.adminJSON(w, http.StatusOK, res)
+}
+
+func (p *Server) adminGetKey(w http.ResponseWriter, r *http.Request) {
+	key, err := adminURLParam(r, "key")
+	if err != nil {
+		p.adminError(w, http.StatusBadRequest, err)
+		return
+	}
+	entry, ok, err := p.cacher.Cacher().GetEntry(key)
+	if err != nil {
+		p.adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		p.adminJSON(w, http.StatusNotFound, map[string]string{"error": "key is not found"})
+		return
+	}
+	p.adminJSON(w, http.StatusOK, entry)
+}
+
+func (p *Server) adminPurgeKey(w http.ResponseWriter, r *http.Request) {
+	key, err := adminURLParam(r, "key")
+	if err != nil {
+		p.adminError(w, http.StatusBadRequest, err)
+		return
+	}
+	_, ok, err := p.cacher.Cacher().GetEntry(key)
+	if err != nil {
+		p.adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		p.adminJSON(w, http.StatusNotFound, map[string]string{"error": "key is not found"})
+		return
+	}
+	if err := p.cacher.Cacher().Delete(key); err != nil {
+		p.adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	p.logger.Infof("Purged cached key %s", key)
+	p.adminJSON(w, http.StatusOK, adminPurged{Purged: 1})
+}
+
+func (p *Server) adminPurgeMethod(w http.ResponseWriter, r *http.Request) {
+	method, err := adminURLParam(r, "method")
+	if err != nil {
+		p.adminError(w, http.StatusBadRequest, err)
+		return
+	}
+	purged, err := p.cacher.PurgeMatching(func(m string) bool {
+		return m == method
+	})
+	if err != nil {
+		p.adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	p.logger.Infof("Purged %d cached keys of method %s", purged, method)
+	p.adminJSON(w, http.StatusOK, adminPurged{Purged: purged})
+}
+
+func (p *Server) adminPurgeAll(w http.ResponseWriter, _ *http.Request) {
+	if err := p.cacher.Cacher().Clean(); err != nil {
+		p.adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	p.logger.Info("Purged all cached keys")
+	p.adminJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (p *Server) adminStats(w http.ResponseWriter, _ *http.Request) {
+	entries, err := p.cacher.Cacher().Entries()
+	if err != nil {
+		p.adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	p.adminJSON(w, http.StatusOK, cache.NewStats(entries, time.Now()))
+}
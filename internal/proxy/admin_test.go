@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+)
+
+func adminRequest(t *testing.T, method, url string, perms []string, secret []byte, v interface{}) int {
+	jwtToken, err := auth.NewJWT(secret, "HS256", perms)
+	require.NoError(t, err)
+	req, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", jwtToken))
+	resp, err := (&http.Client{}).Do(req)
+	require.NoError(t, err)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if v != nil && resp.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+	return resp.StatusCode
+}
+
+func TestServerAdminAPI(t *testing.T) {
+	conf, err := testhelpers.GetConfig("http://test.com", testMethod, "other")
+	require.NoError(t, err)
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	frontend := httptest.NewServer(PrepareRoutes(conf, logger.Log, server))
+	defer frontend.Close()
+
+	cacher := server.cacher.Cacher()
+	for idx, method := range []string{testMethod, testMethod, "other"} {
+		request := requests.RPCRequest{JSONRPC: "2.0", ID: idx, Method: method, Params: []interface{}{idx}}
+		response := requests.RPCResponse{JSONRPC: "2.0", ID: idx, Result: idx}
+		require.NoError(t, cacher.Set(fmt.Sprintf("key/%d", idx), request, response, 0, 0))
+	}
+	admin := []string{"read", adminPermission}
+	adminURL := fmt.Sprintf("%s/admin/cache", frontend.URL)
+
+	t.Run("forbidden", func(t *testing.T) {
+		code := adminRequest(t, http.MethodGet, adminURL+"/stats", conf.JWTPermissions, conf.JWT(), nil)
+		require.Equal(t, http.StatusForbidden, code)
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		keys := adminKeys{}
+		code := adminRequest(t, http.MethodGet, adminURL+"/keys?limit=1&offset=1", admin, conf.JWT(), &keys)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 3, keys.Total)
+		require.Len(t, keys.Keys, 1)
+		require.Equal(t, "key/1", keys.Keys[0].Key)
+		require.Equal(t, testMethod, keys.Keys[0].Method)
+		require.Greater(t, keys.Keys[0].Size, 0)
+
+		keys = adminKeys{}
+		code = adminRequest(t, http.MethodGet, adminURL+"/keys?method=other", admin, conf.JWT(), &keys)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 1, keys.Total)
+		require.Equal(t, "key/2", keys.Keys[0].Key)
+
+		code = adminRequest(t, http.MethodGet, adminURL+"/keys?limit=0", admin, conf.JWT(), nil)
+		require.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("entry", func(t *testing.T) {
+		entry := struct {
+			Request  requests.RPCRequest  `json:"request"`
+			Response requests.RPCResponse `json:"response"`
+		}{}
+		code := adminRequest(t, http.MethodGet, adminURL+"/keys/"+url.PathEscape("key/0"), admin, conf.JWT(), &entry)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, testMethod, entry.Request.Method)
+		require.EqualValues(t, 0, entry.Response.Result)
+
+		code = adminRequest(t, http.MethodGet, adminURL+"/keys/missing", admin, conf.JWT(), nil)
+		require.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		stats := cache.Stats{}
+		code := adminRequest(t, http.MethodGet, adminURL+"/stats", admin, conf.JWT(), &stats)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 3, stats.Entries)
+		require.Equal(t, 2, stats.Methods[testMethod].Entries)
+		require.Equal(t, 1, stats.Methods["other"].Entries)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		purged := adminPurged{}
+		code := adminRequest(t, http.MethodDelete, adminURL+"/methods/"+testMethod, admin, conf.JWT(), &purged)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 2, purged.Purged)
+
+		code = adminRequest(t, http.MethodDelete, adminURL+"/keys/"+url.PathEscape("key/2"), admin, conf.JWT(), &purged)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 1, purged.Purged)
+		entries, err := cacher.Entries()
+		require.NoError(t, err)
+		require.Empty(t, entries)
+		code = adminRequest(t, http.MethodDelete, adminURL+"/keys/"+url.PathEscape("key/2"), admin, conf.JWT(), nil)
+		require.Equal(t, http.StatusNotFound, code)
+
+		require.NoError(t, cacher.Set("key", requests.RPCRequest{Method: "other"}, requests.RPCResponse{Result: 1}, 0, 0))
+		code = adminRequest(t, http.MethodDelete, adminURL, admin, conf.JWT(), nil)
+		require.Equal(t, http.StatusOK, code)
+		entries, err = cacher.Entries()
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+}
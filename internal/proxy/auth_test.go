@@ -46,6 +46,27 @@ func TestServerAuxiliaryFunc(t *testing.T) {
 	}
 }
 
+func TestServerReadyFunc(t *testing.T) {
+	conf, err := testhelpers.GetConfig("http://test.com", testMethod)
+	require.NoError(t, err)
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	s := httptest.NewServer(PrepareRoutes(conf, logger.Log, server))
+	defer s.Close()
+
+	for _, ready := range []bool{false, true} {
+		server.SetReady(ready)
+		resp, err := http.Get(fmt.Sprintf("%s/ready", s.URL))
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		if ready {
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+		} else {
+			require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		}
+	}
+}
+
 func TestServerJWTAuthFunc401(t *testing.T) {
 	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Content-Type", "application/json")
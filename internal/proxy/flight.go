@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+)
+
+// flightResult is upstream response shared by coalesced requests
+type flightResult struct {
+	statusCode int
+	body       []byte
+	responses  requests.RPCResponses
+	parseErr   error
+}
+
+type flight struct {
+	done    chan struct{}
+	result  flightResult
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// flightGroup coalesces concurrent calls with the same key into one call
+type flightGroup struct {
+	lock    sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		flights: make(map[string]*flight),
+	}
+}
+
+// do runs fn once for concurrent calls with the same key and returns its result to all of them.
+// fn context is cancelled only when every caller context is done. shared reports whether the call joined a running one
+func (g *flightGroup) do(
+	ctx context.Context,
+	key string,
+	fn func(ctx context.Context) (flightResult, error),
+) (result flightResult, shared bool, err error) {
+	g.lock.Lock()
+	f, shared := g.flights[key]
+	if !shared {
+		flightCtx, cancel := context.WithCancel(context.Background())
+		f = &flight{
+			done:   make(chan struct{}),
+			cancel: cancel,
+		}
+		g.flights[key] = f
+		go func() {
+			f.result, f.err = fn(flightCtx)
+			g.forget(key, f)
+			cancel()
+			close(f.done)
+		}()
+	}
+	f.waiters++
+	g.lock.Unlock()
+
+	select {
+	case <-f.done:
+		return f.result, shared, f.err
+	case <-ctx.Done():
+		g.lock.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			// nobody waits for the result anymore
+			f.cancel()
+			g.forgetLocked(key, f)
+		}
+		g.lock.Unlock()
+		return flightResult{}, shared, ctx.Err()
+	}
+}
+
+func (g *flightGroup) forget(key string, f *flight) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.forgetLocked(key, f)
+}
+
+func (g *flightGroup) forgetLocked(key string, f *flight) {
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+
+	"github.com/stretchr/testify/require"
+)
+
+func waitFlightWaiters(t *testing.T, g *flightGroup, key string, n int) {
+	require.Eventually(t, func() bool {
+		g.lock.Lock()
+		defer g.lock.Unlock()
+		f, ok := g.flights[key]
+		return ok && f.waiters == n
+	}, time.Second, time.Millisecond)
+}
+
+func TestFlightGroupCoalesces(t *testing.T) {
+	g := newFlightGroup()
+	release := make(chan struct{})
+	var calls int32
+	fn := func(ctx context.Context) (flightResult, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return flightResult{responses: requests.RPCResponses{{Result: "result"}}}, nil
+	}
+
+	wg := sync.WaitGroup{}
+	var sharedCalls int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, shared, err := g.do(context.Background(), "key", fn)
+			require.NoError(t, err)
+			require.Equal(t, "result", result.responses[0].Result)
+			if shared {
+				atomic.AddInt32(&sharedCalls, 1)
+			}
+		}()
+	}
+	waitFlightWaiters(t, g, "key", 5)
+	close(release)
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	require.Equal(t, int32(4), atomic.LoadInt32(&sharedCalls))
+	require.Empty(t, g.flights)
+}
+
+func TestFlightGroupLeaderCancel(t *testing.T) {
+	g := newFlightGroup()
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (flightResult, error) {
+		select {
+		case <-release:
+			return flightResult{statusCode: 200}, nil
+		case <-ctx.Done():
+			return flightResult{}, ctx.Err()
+		}
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error)
+	go func() {
+		_, _, err := g.do(leaderCtx, "key", fn)
+		leaderErr <- err
+	}()
+	waitFlightWaiters(t, g, "key", 1)
+	followerResult := make(chan flightResult)
+	go func() {
+		result, _, err := g.do(context.Background(), "key", fn)
+		require.NoError(t, err)
+		followerResult <- result
+	}()
+	waitFlightWaiters(t, g, "key", 2)
+
+	// the upstream call keeps running for the follower
+	cancelLeader()
+	require.True(t, errors.Is(<-leaderErr, context.Canceled))
+	close(release)
+	require.Equal(t, 200, (<-followerResult).statusCode)
+}
+
+func TestFlightGroupAllCancel(t *testing.T) {
+	g := newFlightGroup()
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) (flightResult, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return flightResult{}, ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, _, err := g.do(ctx, "key", fn)
+			errs <- err
+		}()
+	}
+	waitFlightWaiters(t, g, "key", 2)
+	cancel()
+	<-cancelled
+	for i := 0; i < 2; i++ {
+		require.True(t, errors.Is(<-errs, context.Canceled))
+	}
+}
@@ -2,11 +2,14 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
@@ -18,12 +21,28 @@ import (
 	"github.com/go-chi/chi/middleware"
 )
 
+// StaleHeader marks responses containing stale cached responses
+const StaleHeader = "X-rpc-proxy-stale"
+
 type transport struct {
 	logger            *logrus.Entry
 	cacher            ResponseCacher
 	proxyURL          *url.URL
+	upstreamTimeout   time.Duration
 	debugHTTPRequest  bool
 	debugHTTPResponse bool
+	// cache keys being refreshed in background
+	revalidating sync.Map
+	flights      *flightGroup
+}
+
+// cachedResponses are responses found in the cache by request positions
+type cachedResponses struct {
+	responses requests.RPCResponses
+	// positions of stale responses served while they are refreshed
+	stale []int
+	// stale responses served if the upstream fails
+	fallbacks map[int]requests.RPCResponse
 }
 
 // nolint
@@ -33,6 +52,7 @@ func NewTransport(cacher ResponseCacher, logger *logrus.Entry, debugHTTPRequest,
 		cacher:            cacher,
 		debugHTTPRequest:  debugHTTPRequest,
 		debugHTTPResponse: debugHttpResponse,
+		flights:           newFlightGroup(),
 	}
 }
 
@@ -61,10 +81,16 @@ func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 		metrics.SetRequestsCounterByMethod(method)
 	}
 
-	preparedResponses, err := t.fromCache(parsedRequests)
+	cached, err := t.fromCache(parsedRequests)
 	if err != nil {
 		log.Errorf("Cannot build prepared responses: %v", err)
-		preparedResponses = make(requests.RPCResponses, len(parsedRequests))
+		cached = cachedResponses{responses: make(requests.RPCResponses, len(parsedRequests))}
+	}
+	preparedResponses := cached.responses
+	if len(cached.stale) > 0 {
+		staleRequests := parsedRequests.FindByPositions(cached.stale...)
+		metrics.SetRequestsStaleCounterByMethods(staleRequests.Methods()...)
+		go t.revalidate(req.Clone(context.Background()), staleRequests, log)
 	}
 
 	cachedRequestIdx, proxyRequestIdx := preparedResponses.SplitEmptyResponsePositions()
@@ -82,7 +108,7 @@ func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	switch len(proxyRequests) {
 	case 0:
 		log.Debug("returning proxy response...")
-		return preparedResponses.Response()
+		return staleResponse(preparedResponses, len(cached.stale) > 0)
 	case 1:
 		proxyBody, err = json.Marshal(proxyRequests[0])
 	default:
@@ -99,9 +125,29 @@ func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	if t.debugHTTPRequest {
 		requests.DebugRequest(req, log)
 	}
-	res, err := http.DefaultTransport.RoundTrip(req)
+	if key, ok := t.flightKey(proxyRequests); ok {
+		return t.roundTripCoalesced(req, key, cached, proxyRequestIdx[0], proxyRequests[0], methods, start, log)
+	}
+	ctx, cancel := t.upstreamContext(req.Context())
+	res, err := http.DefaultTransport.RoundTrip(req.WithContext(ctx))
 	elapsed := time.Since(start)
 	metrics.SetRequestDuration(elapsed.Milliseconds())
+	if err != nil {
+		cancel()
+	} else {
+		// the response body may be read after returning, so the timeout is kept until it is closed
+		res.Body = cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+	}
+	if err != nil || res.StatusCode >= http.StatusInternalServerError {
+		if ok := cached.fallback(proxyRequestIdx); ok {
+			if err == nil {
+				_ = res.Body.Close()
+			}
+			log.Warnf("Upstream failed, returning stale cached responses...")
+			metrics.SetRequestsStaleCounterByMethods(proxyRequests.Methods()...)
+			return staleResponse(preparedResponses, true)
+		}
+	}
 	if err != nil {
 		metrics.SetRequestsErrorCounterByMethods(methods...)
 		return res, err
@@ -114,25 +160,29 @@ func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 		return res, nil
 	}
 	responses, body, err := requests.ParseResponses(res)
+	if errors.Is(err, context.DeadlineExceeded) && cached.fallback(proxyRequestIdx) {
+		log.Warnf("Upstream timed out, returning stale cached responses...")
+		metrics.SetRequestsStaleCounterByMethods(proxyRequests.Methods()...)
+		return staleResponse(preparedResponses, true)
+	}
 	if err != nil {
 		metrics.SetRequestsErrorCounterByMethods(methods...)
 		return requests.JSONRPCErrorResponse(res.StatusCode, body)
 	}
 
 	for idx, response := range responses {
-		if response.Error == nil {
-			if request, ok := parsedRequests.FindByID(response.ID); ok {
-				if t.cacher.Matcher().IsCacheable(request.Method) {
-					if err := t.cacher.SetResponseCache(request, response); err != nil {
-						t.logger.Errorf("Cannot set cached response: %v", err)
-					}
+		// error responses are cached only if the method caches their codes
+		if request, ok := parsedRequests.FindByID(response.ID); ok {
+			if t.cacher.Matcher().IsCacheable(request.Method) {
+				if err := t.cacher.SetResponseCache(request, response); err != nil {
+					t.logger.Errorf("Cannot set cached response: %v", err)
 				}
 			}
 		}
 		preparedResponses[proxyRequestIdx[idx]] = response
 	}
 
-	resp, err := preparedResponses.Response()
+	resp, err := staleResponse(preparedResponses, len(cached.stale) > 0)
 	if err != nil {
 		t.logger.Errorf("Cannot prepare response from cached responses: %v", err)
 		return resp, err
@@ -140,6 +190,203 @@ func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	return resp, nil
 }
 
+// flightKey returns the cache key to coalesce upstream requests by. Only single requests are coalesced
+func (t *transport) flightKey(reqs requests.RPCRequests) (string, bool) {
+	if len(reqs) != 1 {
+		return "", false
+	}
+	keys := t.cacher.Matcher().Keys(reqs[0].Method, reqs[0].Params)
+	if len(keys) == 0 {
+		return "", false
+	}
+	return keys[0].Key, true
+}
+
+// roundTripCoalesced forwards the request unless the same request is being forwarded already
+// and returns the shared upstream response
+func (t *transport) roundTripCoalesced(
+	req *http.Request,
+	key string,
+	cached cachedResponses,
+	proxyRequestIdx int,
+	request requests.RPCRequest,
+	methods []string,
+	start time.Time,
+	log *logrus.Entry,
+) (*http.Response, error) {
+	result, shared, err := t.flights.do(req.Context(), key, func(ctx context.Context) (flightResult, error) {
+		return t.forward(req.Clone(ctx), request, log)
+	})
+	elapsed := time.Since(start)
+	metrics.SetRequestDuration(elapsed.Milliseconds())
+	if shared {
+		log.Debug("Coalesced request with the running one...")
+		metrics.SetRequestsCoalescedCounterByMethod(request.Method)
+	}
+	if err != nil || result.statusCode >= http.StatusInternalServerError {
+		if ok := cached.fallback([]int{proxyRequestIdx}); ok {
+			log.Warnf("Upstream failed, returning stale cached responses...")
+			metrics.SetRequestsStaleCounterByMethods(request.Method)
+			return staleResponse(cached.responses, true)
+		}
+	}
+	if err != nil {
+		metrics.SetRequestsErrorCounterByMethods(methods...)
+		return nil, err
+	}
+	if result.parseErr != nil {
+		metrics.SetRequestsErrorCounterByMethods(methods...)
+		return requests.JSONRPCErrorResponse(result.statusCode, result.body)
+	}
+	for _, response := range result.responses {
+		// the upstream response is shared, so it gets the ID of this request
+		response.ID = request.ID
+		cached.responses[proxyRequestIdx] = response
+	}
+	return staleResponse(cached.responses, len(cached.stale) > 0)
+}
+
+// forward sends the request upstream and caches the response
+func (t *transport) forward(req *http.Request, request requests.RPCRequest, log *logrus.Entry) (flightResult, error) {
+	ctx, cancel := t.upstreamContext(req.Context())
+	defer cancel()
+	res, err := http.DefaultTransport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		return flightResult{}, err
+	}
+	if t.debugHTTPResponse {
+		requests.DebugResponse(res, log)
+	}
+	responses, body, err := requests.ParseResponses(res)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return flightResult{}, err
+	}
+	result := flightResult{
+		statusCode: res.StatusCode,
+		body:       body,
+		responses:  responses,
+		parseErr:   err,
+	}
+	if err != nil || !t.cacher.Matcher().IsCacheable(request.Method) {
+		return result, nil
+	}
+	for _, response := range responses {
+		if err := t.cacher.SetResponseCache(request, response); err != nil {
+			log.Errorf("Cannot set cached response: %v", err)
+		}
+	}
+	return result, nil
+}
+
+// upstreamContext limits upstream requests with the upstream timeout. Zero timeout means no limit
+func (t *transport) upstreamContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.upstreamTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, t.upstreamTimeout)
+}
+
+// cancelOnClose cancels the upstream request context when the response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// staleResponse prepares response marked with StaleHeader if it contains stale responses
+func staleResponse(responses requests.RPCResponses, stale bool) (*http.Response, error) {
+	resp, err := responses.Response()
+	if err != nil || !stale {
+		return resp, err
+	}
+	resp.Header.Set(StaleHeader, "true")
+	return resp, nil
+}
+
+// fallback replaces responses at the positions with stale responses. false means some of them have no stale response
+func (c cachedResponses) fallback(positions []int) bool {
+	for _, idx := range positions {
+		if _, ok := c.fallbacks[idx]; !ok {
+			return false
+		}
+	}
+	for _, idx := range positions {
+		c.responses[idx] = c.fallbacks[idx]
+	}
+	return true
+}
+
+// revalidate refreshes cached responses of the requests using the original request
+func (t *transport) revalidate(req *http.Request, reqs requests.RPCRequests, log *logrus.Entry) {
+	var keys []string
+	defer func() {
+		for _, key := range keys {
+			t.revalidating.Delete(key)
+		}
+	}()
+	var refresh requests.RPCRequests
+	for _, request := range reqs {
+		cacheKeys := t.cacher.Matcher().Keys(request.Method, request.Params)
+		if len(cacheKeys) == 0 {
+			continue
+		}
+		// skip requests being refreshed already
+		if _, loaded := t.revalidating.LoadOrStore(cacheKeys[0].Key, struct{}{}); !loaded {
+			keys = append(keys, cacheKeys[0].Key)
+			refresh = append(refresh, request)
+		}
+	}
+	if len(refresh) == 0 {
+		return
+	}
+	reqs = refresh
+
+	var body []byte
+	var err error
+	if len(reqs) == 1 {
+		body, err = json.Marshal(reqs[0])
+	} else {
+		body, err = json.Marshal(reqs)
+	}
+	if err != nil {
+		log.Errorf("Cannot prepare revalidation request: %v", err)
+		return
+	}
+	req.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	req.ContentLength = int64(len(body))
+	req.Host = t.proxyURL.Host
+	log.Debug("Revalidating stale cached responses...")
+	// hung upstream must not keep the keys in revalidating forever
+	ctx, cancel := t.upstreamContext(req.Context())
+	defer cancel()
+	res, err := http.DefaultTransport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		log.Errorf("Cannot revalidate stale cached responses: %v", err)
+		return
+	}
+	responses, _, err := requests.ParseResponses(res)
+	if err != nil {
+		log.Errorf("Cannot parse revalidated responses: %v", err)
+		return
+	}
+	for _, response := range responses {
+		// stale responses are kept on errors
+		if response.Error != nil {
+			continue
+		}
+		if request, ok := reqs.FindByID(response.ID); ok {
+			if err := t.cacher.SetResponseCache(request, response); err != nil {
+				log.Errorf("Cannot set cached response: %v", err)
+			}
+		}
+	}
+}
+
 func (t *transport) isCacheableRequests(reqs requests.RPCRequests) bool {
 	for _, req := range reqs {
 		if !t.cacher.Matcher().IsCacheable(req.Method) {
@@ -150,10 +397,13 @@ func (t *transport) isCacheableRequests(reqs requests.RPCRequests) bool {
 }
 
 // fromCache checks presence of messages in the cache
-func (t *transport) fromCache(reqs requests.RPCRequests) (requests.RPCResponses, error) {
-	results := make(requests.RPCResponses, len(reqs))
+func (t *transport) fromCache(reqs requests.RPCRequests) (cachedResponses, error) {
+	results := cachedResponses{
+		responses: make(requests.RPCResponses, len(reqs)),
+		fallbacks: make(map[int]requests.RPCResponse),
+	}
 	for idx, request := range reqs {
-		response, err := t.cacher.GetResponseCache(request)
+		response, stale, err := t.getResponseCache(request)
 		if err != nil {
 			cacheErr := &cache.Error{}
 			if errors.As(err, cacheErr) {
@@ -163,11 +413,29 @@ func (t *transport) fromCache(reqs requests.RPCRequests) (requests.RPCResponses,
 			}
 		}
 		response.ID = request.ID
-		results[idx] = response
+		switch {
+		case !stale:
+			results.responses[idx] = response
+		case t.cacher.Matcher().IsStaleWhileRevalidate(request.Method):
+			results.responses[idx] = response
+			results.stale = append(results.stale, idx)
+		default:
+			results.fallbacks[idx] = response
+		}
 	}
 	return results, nil
 }
 
+// getResponseCache returns the cached response. Stale responses are returned only for methods serving them
+func (t *transport) getResponseCache(request requests.RPCRequest) (requests.RPCResponse, bool, error) {
+	m := t.cacher.Matcher()
+	if m.IsStaleWhileRevalidate(request.Method) || m.IsStaleIfError(request.Method) {
+		return t.cacher.GetStaleResponseCache(request)
+	}
+	response, err := t.cacher.GetResponseCache(request)
+	return response, false, err
+}
+
 func (t *transport) Close() error {
 	return t.cacher.Cacher().Close()
 }
@@ -9,7 +9,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
 
@@ -350,3 +353,242 @@ func TestTransportBulkRequestReverseResponses(t *testing.T) {
 		require.Equal(t, resp.ID, req.ID)
 	}
 }
+
+func staleTestServer(t *testing.T, backendURL string, staleWhileRevalidate, staleIfError bool) (*Server, *httptest.Server) {
+	conf, err := testhelpers.GetConfig(backendURL, method)
+	require.NoError(t, err)
+	conf.CacheMethods[0].TTL = 1
+	conf.CacheMethods[0].MaxStale = 60
+	conf.CacheMethods[0].StaleWhileRevalidate = staleWhileRevalidate
+	conf.CacheMethods[0].StaleIfError = staleIfError
+	require.NoError(t, conf.Validate())
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	return server, httptest.NewServer(http.HandlerFunc(server.RPCProxy))
+}
+
+func TestTransportStaleIfError(t *testing.T) {
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		_, err := fmt.Fprint(w, `{"jsonrpc": "2.0", "id": 1, "result": 15}`)
+		if err != nil {
+			logger.Log.Error(err)
+		}
+	}))
+	defer backend.Close()
+	server, frontend := staleTestServer(t, backend.URL, false, true)
+	defer frontend.Close()
+
+	body := `{"jsonrpc": "2.0", "id": 1, "method": "test", "params": ["1"]}`
+	resp, err := http.Post(frontend.URL, "application/json", bytes.NewBufferString(body))
+	require.NoError(t, err)
+	require.Empty(t, resp.Header.Get(StaleHeader))
+	_ = resp.Body.Close()
+
+	time.Sleep(1100 * time.Millisecond)
+	cached, err := server.transport.cacher.GetResponseCache(requests.RPCRequest{Method: method, Params: []interface{}{"1"}})
+	require.NoError(t, err)
+	require.True(t, cached.IsEmpty())
+
+	resp, err = http.Post(frontend.URL, "application/json", bytes.NewBufferString(body))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "true", resp.Header.Get(StaleHeader))
+	responses, _, err := requests.ParseResponses(resp)
+	require.NoError(t, err)
+	require.Len(t, responses, 1)
+	require.Equal(t, float64(15), responses[0].Result)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestTransportStaleIfErrorTimeout(t *testing.T) {
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			// hung upstream
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		_, err := fmt.Fprint(w, `{"jsonrpc": "2.0", "id": 1, "result": 15}`)
+		if err != nil {
+			logger.Log.Error(err)
+		}
+	}))
+	defer backend.Close()
+	server, frontend := staleTestServer(t, backend.URL, false, true)
+	defer frontend.Close()
+	server.transport.upstreamTimeout = 200 * time.Millisecond
+
+	body := `{"jsonrpc": "2.0", "id": 1, "method": "test", "params": ["1"]}`
+	resp, err := http.Post(frontend.URL, "application/json", bytes.NewBufferString(body))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	time.Sleep(1100 * time.Millisecond)
+	start := time.Now()
+	resp, err = http.Post(frontend.URL, "application/json", bytes.NewBufferString(body))
+	require.NoError(t, err)
+	require.Less(t, int64(time.Since(start)), int64(2*time.Second))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "true", resp.Header.Get(StaleHeader))
+	responses, _, err := requests.ParseResponses(resp)
+	require.NoError(t, err)
+	require.Len(t, responses, 1)
+	require.Equal(t, float64(15), responses[0].Result)
+}
+
+func TestTransportStaleWhileRevalidate(t *testing.T) {
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		_, err := fmt.Fprintf(w, `{"jsonrpc": "2.0", "id": 1, "result": %d}`, atomic.AddInt32(&calls, 1))
+		if err != nil {
+			logger.Log.Error(err)
+		}
+	}))
+	defer backend.Close()
+	server, frontend := staleTestServer(t, backend.URL, true, false)
+	defer frontend.Close()
+
+	body := `{"jsonrpc": "2.0", "id": 1, "method": "test", "params": ["1"]}`
+	resp, err := http.Post(frontend.URL, "application/json", bytes.NewBufferString(body))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	time.Sleep(1100 * time.Millisecond)
+	resp, err = http.Post(frontend.URL, "application/json", bytes.NewBufferString(body))
+	require.NoError(t, err)
+	require.Equal(t, "true", resp.Header.Get(StaleHeader))
+	responses, _, err := requests.ParseResponses(resp)
+	require.NoError(t, err)
+	require.Len(t, responses, 1)
+	require.Equal(t, float64(1), responses[0].Result)
+
+	request := requests.RPCRequest{Method: method, Params: []interface{}{"1"}}
+	require.Eventually(t, func() bool {
+		cached, err := server.transport.cacher.GetResponseCache(request)
+		return err == nil && cached.Result == float64(2)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTransportCoalescesRequests(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Header().Add("Content-Type", "application/json")
+		_, err := fmt.Fprint(w, `{"jsonrpc": "2.0", "id": 0, "result": 15}`)
+		if err != nil {
+			logger.Log.Error(err)
+		}
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfig(backend.URL, method)
+	require.NoError(t, err)
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	frontend := httptest.NewServer(http.HandlerFunc(server.RPCProxy))
+	defer frontend.Close()
+
+	clients := 5
+	results := make(chan requests.RPCResponse, clients)
+	for i := 0; i < clients; i++ {
+		go func(id int) {
+			body := fmt.Sprintf(`{"jsonrpc": "2.0", "id": %d, "method": "test", "params": ["1"]}`, id)
+			resp, err := http.Post(frontend.URL, "application/json", bytes.NewBufferString(body))
+			require.NoError(t, err)
+			responses, _, err := requests.ParseResponses(resp)
+			require.NoError(t, err)
+			require.Len(t, responses, 1)
+			results <- responses[0]
+		}(i)
+	}
+	key := server.transport.cacher.Matcher().Keys(method, []interface{}{"1"})[0].Key
+	waitFlightWaiters(t, server.transport.flights, key, clients)
+	close(release)
+
+	ids := map[interface{}]struct{}{}
+	for i := 0; i < clients; i++ {
+		response := <-results
+		require.Equal(t, float64(15), response.Result)
+		ids[response.ID] = struct{}{}
+	}
+	require.Len(t, ids, clients)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestTransportCacheConditions(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs requests.RPCRequests
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&reqs))
+		var responses []string
+		for _, req := range reqs {
+			id, _ := json.Marshal(req.ID)
+			switch req.Params.([]interface{})[0] {
+			case "missing":
+				responses = append(responses, fmt.Sprintf(`{"jsonrpc": "2.0", "id": %s, "result": null}`, id))
+			case "error":
+				responses = append(responses, fmt.Sprintf(`{"jsonrpc": "2.0", "id": %s, "error": {"code": 1, "message": "not found"}}`, id))
+			case "failure":
+				responses = append(responses, fmt.Sprintf(`{"jsonrpc": "2.0", "id": %s, "error": {"code": 2, "message": "failure"}}`, id))
+			default:
+				responses = append(responses, fmt.Sprintf(`{"jsonrpc": "2.0", "id": %s, "result": {"Receipt": {}}}`, id))
+			}
+		}
+		w.Header().Add("Content-Type", "application/json")
+		_, err := fmt.Fprintf(w, "[%s]", strings.Join(responses, ","))
+		if err != nil {
+			logger.Log.Error(err)
+		}
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfig(backend.URL, method)
+	require.NoError(t, err)
+	conf.CacheMethods[0].TTL = 60
+	conf.CacheMethods[0].CacheIf.NotEmpty = true
+	conf.CacheMethods[0].CacheErrors = []int{1}
+	conf.CacheMethods[0].ErrorTTL = 1
+	require.NoError(t, conf.Validate())
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	frontend := httptest.NewServer(http.HandlerFunc(server.RPCProxy))
+	defer frontend.Close()
+
+	var reqs requests.RPCRequests
+	for idx, param := range []string{"found", "missing", "error", "failure"} {
+		reqs = append(reqs, requests.RPCRequest{JSONRPC: "2.0", ID: float64(idx), Method: method, Params: []interface{}{param}})
+	}
+	body, err := json.Marshal(reqs)
+	require.NoError(t, err)
+	resp, err := http.Post(frontend.URL, "application/json", bytes.NewBuffer(body))
+	require.NoError(t, err)
+	responses, _, err := requests.ParseResponses(resp)
+	require.NoError(t, err)
+	require.Len(t, responses, len(reqs))
+
+	cached := func(req requests.RPCRequest) requests.RPCResponse {
+		res, err := server.transport.cacher.GetResponseCache(req)
+		require.NoError(t, err)
+		return res
+	}
+	require.False(t, cached(reqs[0]).IsEmpty())
+	require.True(t, cached(reqs[1]).IsEmpty())
+	require.NotNil(t, cached(reqs[2]).Error)
+	require.True(t, cached(reqs[3]).IsEmpty())
+
+	time.Sleep(1100 * time.Millisecond)
+	require.True(t, cached(reqs[2]).IsEmpty())
+	require.False(t, cached(reqs[0]).IsEmpty())
+}
@@ -1,9 +1,13 @@
 package proxy
 
 import (
+	"sync"
+
 	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/chain"
 	"github.com/protofire/filecoin-rpc-proxy/internal/matcher"
 	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
 )
@@ -12,13 +16,17 @@ import (
 type ResponseCache struct {
 	cache   cache.Cache
 	matcher matcher.Matcher
+	// keys of responses at the chain head set since the last new head
+	headLock sync.Mutex
+	headKeys map[string]struct{}
 }
 
 // NewResponseCache fabric
 func NewResponseCache(cache cache.Cache, matcher matcher.Matcher) *ResponseCache {
 	return &ResponseCache{
-		cache:   cache,
-		matcher: matcher,
+		cache:    cache,
+		matcher:  matcher,
+		headKeys: make(map[string]struct{}),
 	}
 }
 
@@ -26,19 +34,31 @@ func NewResponseCache(cache cache.Cache, matcher matcher.Matcher) *ResponseCache
 type ResponseCacher interface {
 	SetResponseCache(requests.RPCRequest, requests.RPCResponse) error
 	GetResponseCache(req requests.RPCRequest) (requests.RPCResponse, error)
+	GetStaleResponseCache(req requests.RPCRequest) (requests.RPCResponse, bool, error)
+	PurgeMethods(methods ...string) error
+	PurgeMatching(match func(method string) bool) (int, error)
+	PurgeHeadRelative(scan bool) error
 	Matcher() matcher.Matcher
 	Cacher() cache.Cache
 }
 
-// SetResponseCache sets response cache based on the request
+// SetResponseCache sets response cache based on the request. Responses which do not match cache conditions
+// of the method are skipped
 func (rc *ResponseCache) SetResponseCache(req requests.RPCRequest, resp requests.RPCResponse) error {
-	keys := rc.matcher.Keys(req.Method, req.Params)
+	keys := rc.matcher.ResponseKeys(req.Method, req.Params, resp)
 	if len(keys) == 0 {
 		return nil
 	}
+	if rc.matcher.IsHeadRelative(req.Method, req.Params) {
+		rc.headLock.Lock()
+		for _, key := range keys {
+			rc.headKeys[key.Key] = struct{}{}
+		}
+		rc.headLock.Unlock()
+	}
 	mErr := &multierror.Error{}
 	for _, key := range keys {
-		mErr = multierror.Append(mErr, rc.cache.Set(key.Key, req, resp))
+		mErr = multierror.Append(mErr, rc.cache.Set(key.Key, req, resp, key.TTL, key.MaxStale))
 	}
 	return mErr.ErrorOrNil()
 }
@@ -64,6 +84,96 @@ func (rc *ResponseCache) GetResponseCache(req requests.RPCRequest) (requests.RPC
 	return requests.RPCResponse{}, nil
 }
 
+// GetStaleResponseCache returns response from the cache for the request including the one kept within max_stale.
+// stale reports whether the response is past its ttl
+func (rc *ResponseCache) GetStaleResponseCache(req requests.RPCRequest) (requests.RPCResponse, bool, error) {
+	for _, key := range rc.matcher.Keys(req.Method, req.Params) {
+		resp, stale, err := rc.cache.GetStale(key.Key)
+		if err != nil || resp.IsEmpty() {
+			continue
+		}
+		return resp, stale, nil
+	}
+	return requests.RPCResponse{}, false, nil
+}
+
+// PurgeMethods removes cached responses of the methods
+func (rc *ResponseCache) PurgeMethods(methods ...string) error {
+	if len(methods) == 0 {
+		return nil
+	}
+	purge := make(map[string]struct{}, len(methods))
+	for _, method := range methods {
+		purge[method] = struct{}{}
+	}
+	_, err := rc.PurgeMatching(func(method string) bool {
+		_, ok := purge[method]
+		return ok
+	})
+	return err
+}
+
+// PurgeMatching removes cached responses of methods the function reports true for and returns their number.
+// Scanned keys are removed as is, so entries are purged even if keys of their requests are derived differently now
+func (rc *ResponseCache) PurgeMatching(match func(method string) bool) (int, error) {
+	return cache.PurgeMatching(rc.cache, match)
+}
+
+// PurgeHeadRelative removes cached responses of requests at the chain head. Keys set by this instance are tracked,
+// so the cache is not scanned. scan removes keys set by others as well, e.g. by previous runs
+func (rc *ResponseCache) PurgeHeadRelative(scan bool) error {
+	rc.headLock.Lock()
+	keys := rc.headKeys
+	rc.headKeys = make(map[string]struct{})
+	rc.headLock.Unlock()
+	mErr := &multierror.Error{}
+	if scan {
+		// tracked keys are removed even if the scan fails
+		mErr = multierror.Append(mErr, rc.cache.ScanRequests(func(key string, req requests.RPCRequest) error {
+			if rc.matcher.IsHeadRelative(req.Method, req.Params) {
+				keys[key] = struct{}{}
+			}
+			return nil
+		}))
+	}
+	for key := range keys {
+		mErr = multierror.Append(mErr, rc.cache.Delete(key))
+	}
+	return mErr.ErrorOrNil()
+}
+
+// headInvalidator purges responses at the chain head. The cache is scanned once, following purges remove
+// tracked keys only
+type headInvalidator struct {
+	cacher  ResponseCacher
+	log     *logrus.Entry
+	lock    sync.Mutex
+	scanned bool
+}
+
+func (h *headInvalidator) invalidate(head chain.Head) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.log.Debugf("Invalidating cached responses at height %d...", head.Height)
+	if err := h.cacher.PurgeHeadRelative(!h.scanned); err != nil {
+		h.log.Errorf("Cannot invalidate cached responses: %v", err)
+		return
+	}
+	h.scanned = true
+}
+
+// NewHeadInvalidator returns chain head callback which purges responses at the chain head. Responses are purged
+// in background, so the chain head polling is not blocked
+func NewHeadInvalidator(cacher ResponseCacher, log *logrus.Entry) func(chain.Head) {
+	if len(cacher.Matcher().NewHeadMethods()) == 0 {
+		return func(chain.Head) {}
+	}
+	h := &headInvalidator{cacher: cacher, log: log}
+	return func(head chain.Head) {
+		go h.invalidate(head)
+	}
+}
+
 // Matcher interface implementation
 func (rc *ResponseCache) Matcher() matcher.Matcher {
 	return rc.matcher
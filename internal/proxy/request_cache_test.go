@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/chain"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/matcher"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHeadInvalidator(t *testing.T) {
+	headMethod := "head"
+	conf, err := testhelpers.GetConfig("http://test.com", method, headMethod, "head_*")
+	require.NoError(t, err)
+	conf.CacheMethods[1].InvalidateOn = config.NewHeadInvalidation
+	conf.CacheMethods[2].InvalidateOn = config.NewHeadInvalidation
+	conf.CacheMethods[2].HeadParam = "$[0]"
+	require.NoError(t, conf.Validate())
+	memory := cache.NewMemoryCacheDefault()
+	cacher := NewResponseCache(memory, matcher.FromConfig(conf))
+
+	tsk := []interface{}{map[string]interface{}{"/": "bafy"}}
+	atHead := requests.RPCRequests{
+		{JSONRPC: "2.0", ID: 2, Method: headMethod, Params: []interface{}{"1", []interface{}{}}},
+		{JSONRPC: "2.0", ID: 3, Method: headMethod, Params: []interface{}{"2", nil}},
+		{JSONRPC: "2.0", ID: 4, Method: "head_actor", Params: []interface{}{nil, tsk}},
+	}
+	kept := requests.RPCRequests{
+		{JSONRPC: "2.0", ID: 1, Method: method, Params: []interface{}{"1"}},
+		{JSONRPC: "2.0", ID: 5, Method: headMethod, Params: []interface{}{"1", tsk}},
+		{JSONRPC: "2.0", ID: 6, Method: "head_actor", Params: []interface{}{tsk, nil}},
+	}
+	for _, req := range append(atHead, kept...) {
+		err := cacher.SetResponseCache(req, requests.RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: 1})
+		require.NoError(t, err)
+	}
+	// keys set by others, e.g. by previous runs, are purged by the first scan only
+	legacyResponse := requests.RPCResponse{JSONRPC: "2.0", ID: 2, Result: 1}
+	require.NoError(t, memory.Set("legacy", atHead[0], legacyResponse, 0, 0))
+
+	invalidator := &headInvalidator{cacher: cacher, log: logger.Log}
+	invalidator.invalidate(chain.Head{Height: 1})
+	require.True(t, invalidator.scanned)
+
+	legacy, err := memory.Get("legacy")
+	require.NoError(t, err)
+	require.True(t, legacy.IsEmpty())
+	for _, req := range atHead {
+		resp, err := cacher.GetResponseCache(req)
+		require.NoError(t, err)
+		require.True(t, resp.IsEmpty(), req)
+	}
+	for _, req := range kept {
+		resp, err := cacher.GetResponseCache(req)
+		require.NoError(t, err)
+		require.False(t, resp.IsEmpty(), req)
+	}
+
+	// tracked keys are purged without scanning
+	require.NoError(t, cacher.SetResponseCache(atHead[0], legacyResponse))
+	require.NoError(t, memory.Set("legacy", atHead[0], legacyResponse, 0, 0))
+	invalidator.invalidate(chain.Head{Height: 2})
+	resp, err := cacher.GetResponseCache(atHead[0])
+	require.NoError(t, err)
+	require.True(t, resp.IsEmpty())
+	legacy, err = memory.Get("legacy")
+	require.NoError(t, err)
+	require.False(t, legacy.IsEmpty())
+}
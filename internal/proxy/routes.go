@@ -23,11 +23,13 @@ func PrepareRoutes(c *config.Config, log *logrus.Entry, server *Server) *chi.Mux
 	r.Use(middleware.Recoverer)
 	r.HandleFunc("/healthz", server.HealthFunc)
 	r.HandleFunc("/ready", server.ReadyFunc)
+	r.HandleFunc("/head", server.HeadFunc)
 	r.Handle("/metrics", promhttp.Handler())
 	r.Mount("/debug", middleware.Profiler())
 	r.Group(func(r chi.Router) {
 		r.Use(jwtauth.Verifier(tokenAuth))
 		r.Use(Authenticator)
+		r.Mount("/admin", server.AdminRoutes())
 		r.HandleFunc("/*", server.RPCProxy)
 	})
 	return r
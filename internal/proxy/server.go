@@ -2,11 +2,15 @@ package proxy
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sync/atomic"
+	"time"
 
+	"github.com/protofire/filecoin-rpc-proxy/internal/chain"
 	"github.com/protofire/filecoin-rpc-proxy/internal/matcher"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
@@ -23,6 +27,9 @@ type Server struct {
 	target *url.URL
 	logger *logrus.Entry
 	proxy  *httputil.ReverseProxy
+	head   chain.HeadProvider
+	// 1 means the proxy is ready to serve requests
+	ready int32
 	*transport
 }
 
@@ -41,6 +48,7 @@ func FromConfig(ctx context.Context, c *config.Config) (*Server, error) {
 		matcher.FromConfig(c),
 	)
 	transport := NewTransport(cacher, log, c.DebugHTTPRequest, c.DebugHTTPResponse)
+	transport.upstreamTimeout = time.Duration(c.UpstreamTimeout) * time.Second
 	return newServer(proxyURL, c.Host, c.Port, log, transport)
 }
 
@@ -55,6 +63,7 @@ func newServer(proxyURL *url.URL, host string, port int, log *logrus.Entry, tran
 		target:    proxyURL,
 		logger:    log,
 		proxy:     httputil.NewSingleHostReverseProxy(&hostProxyURL),
+		ready:     1,
 		transport: transport,
 	}
 	s.proxy.Transport = transport
@@ -66,6 +75,7 @@ func FromConfigWithTransport(c *config.Config, log *logrus.Entry, transport *tra
 	if err != nil {
 		return nil, err
 	}
+	transport.upstreamTimeout = time.Duration(c.UpstreamTimeout) * time.Second
 	return newServer(proxyURL, c.Host, c.Port, log, transport)
 }
 
@@ -83,9 +93,50 @@ func (p *Server) HealthFunc(w http.ResponseWriter, _ *http.Request) {
 	}
 }
 
+// SetHeadProvider sets the chain head provider exposed by HeadFunc
+func (p *Server) SetHeadProvider(head chain.HeadProvider) {
+	p.head = head
+}
+
+// HeadFunc returns the tracked chain head
+func (p *Server) HeadFunc(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var head chain.Head
+	ok := false
+	if p.head != nil {
+		head, ok = p.head.Head()
+	}
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if _, err := w.Write([]byte(`{"status": "chain head is not available"}`)); err != nil {
+			p.logger.Errorf("response send error %v", err)
+		}
+		return
+	}
+	if err := json.NewEncoder(w).Encode(head); err != nil {
+		p.logger.Errorf("response send error %v", err)
+	}
+}
+
+// SetReady sets readiness reported by ReadyFunc. The server is ready by default
+func (p *Server) SetReady(ready bool) {
+	var value int32
+	if ready {
+		value = 1
+	}
+	atomic.StoreInt32(&p.ready, value)
+}
+
 // ReadyFunc readiness checking
 func (p *Server) ReadyFunc(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	if atomic.LoadInt32(&p.ready) == 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if _, err := w.Write([]byte(`{"status": "not ready"}`)); err != nil {
+			p.logger.Errorf("response send error %v", err)
+		}
+		return
+	}
 	_, err := w.Write([]byte(`{"status": "ok"}`))
 	if err != nil {
 		p.logger.Errorf("response send error %v", err)
@@ -7,8 +7,8 @@ import (
 	"os"
 	"testing"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/ory/dockertest/v3/docker"
-	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
 
 	"github.com/ory/dockertest/v3"
 	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
@@ -102,6 +102,21 @@ func GetConfigWithCustomMethods(url string, methods ...string) (*config.Config,
 
 }
 
+// pingRedis checks the redis started by TestMain. The cache package is not used, so its tests can use the helpers
+func pingRedis() error {
+	c := redis.NewClient(&redis.Options{Addr: redisHost + ":" + redisPort})
+	defer c.Close()
+	return c.Ping(context.Background()).Err()
+}
+
+// SkipWithoutRedis skips the test if redis is not available on RedisURI, e.g. started by
+// docker run -p 6379:6379 redis
+func SkipWithoutRedis(t *testing.T) {
+	if err := pingRedis(); err != nil {
+		t.Skipf("redis is not available: %v", err)
+	}
+}
+
 func TestMain(m *testing.M) { // nolint
 
 	logger.InitDefaultLogger()
@@ -122,17 +137,7 @@ func TestMain(m *testing.M) { // nolint
 	if err != nil {
 		log.Fatalf("Could not start resource: %s", err)
 	}
-	ctx := context.Background()
-	if err = pool.Retry(func() error {
-		var err error
-		c, err := cache.NewRedisClient(ctx, config.RedisCacheSettings{
-			URI: RedisURI,
-		})
-		if err == nil {
-			_ = c.Close()
-		}
-		return err
-	}); err != nil {
+	if err = pool.Retry(pingRedis); err != nil {
 		log.Fatalf("Could not connect to redis: %s", err)
 	}
 
@@ -11,6 +11,7 @@ import (
 	"github.com/protofire/filecoin-rpc-proxy/internal/proxy"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/chain"
 	"github.com/protofire/filecoin-rpc-proxy/internal/config"
 
 	"github.com/hashicorp/go-multierror"
@@ -30,6 +31,7 @@ type Updater struct {
 	debugHTTPResponse bool
 	batchSize         int
 	concurrency       int
+	head              chain.HeadProvider
 }
 
 func New(
@@ -72,6 +74,11 @@ func FromConfig(conf *config.Config, cacher proxy.ResponseCacher, logger *logrus
 	), nil
 }
 
+// SetHeadProvider sets the chain head provider used by params generators of custom methods
+func (u *Updater) SetHeadProvider(head chain.HeadProvider) {
+	u.head = head
+}
+
 func (u *Updater) start(ctx context.Context, update func() error, period int) {
 
 	ticker := time.NewTicker(time.Second * time.Duration(period))
@@ -126,13 +133,20 @@ func (u *Updater) methodRequests() requests.RPCRequests {
 	reqs := requests.RPCRequests{}
 	counter := float64(1)
 	for _, method := range u.cacher.Matcher().Methods() {
-		reqs = append(reqs, requests.RPCRequest{
-			JSONRPC: "2.0",
-			ID:      counter,
-			Method:  method.Name,
-			Params:  method.Params,
-		})
-		counter++
+		paramsList, err := method.ParamsList(u.head)
+		if err != nil {
+			u.logger.Errorf("Cannot generate params: %v", err)
+			continue
+		}
+		for _, params := range paramsList {
+			reqs = append(reqs, requests.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      counter,
+				Method:  method.Name,
+				Params:  params,
+			})
+			counter++
+		}
 	}
 	return reqs
 }
@@ -140,18 +154,21 @@ func (u *Updater) methodRequests() requests.RPCRequests {
 func (u *Updater) cacheRequests() requests.RPCRequests {
 	reqs := requests.RPCRequests{}
 	counter := float64(1)
-	cacheReqs, err := u.cacher.Cacher().Requests()
-	if err != nil {
-		u.logger.Errorf("Cannot get cache requests: %v", err)
-		return reqs
-	}
-	for _, req := range cacheReqs {
+	err := u.cacher.Cacher().ScanRequests(func(_ string, req requests.RPCRequest) error {
 		if !u.cacher.Matcher().IsUpdatable(req.Method) {
-			continue
+			return nil
+		}
+		// responses for finalized epochs never change
+		if u.cacher.Matcher().IsFinalized(req.Method, req.Params) {
+			return nil
 		}
 		req.ID = counter
 		reqs = append(reqs, req)
 		counter++
+		return nil
+	})
+	if err != nil {
+		u.logger.Errorf("Cannot get cache requests: %v", err)
 	}
 	return reqs
 }
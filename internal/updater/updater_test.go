@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/protofire/filecoin-rpc-proxy/internal/chain"
 	"github.com/protofire/filecoin-rpc-proxy/internal/config"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/utils"
@@ -337,3 +339,87 @@ func TestMethodsUpdaterConcurrency(t *testing.T) {
 	lock.Unlock()
 
 }
+
+func TestWarmup(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs, err := requests.ParseRequests(r)
+		require.NoError(t, err)
+		responses := requests.RPCResponses{}
+		for _, req := range reqs {
+			responses = append(responses, requests.RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: req.Params})
+		}
+		w.Header().Add("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(responses))
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfig(backend.URL, method)
+	require.NoError(t, err)
+	conf.RequestsBatchSize = 2
+	cacher := proxy.NewResponseCache(
+		cache.NewMemoryCacheFromConfig(conf.CacheSettings.Memory),
+		matcher.FromConfig(conf),
+	)
+	updaterImp, err := FromConfig(conf, cacher, logger.Log)
+	require.NoError(t, err)
+
+	reqs, err := readWarmupRequests(strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "test", "params": ["1"]}
+{"jsonrpc": "2.0", "id": 1, "method": "test", "params": ["2"]}
+
+{"jsonrpc": "2.0", "id": 1, "method": "other", "params": ["3"]}
+{"jsonrpc": "2.0", "id": 1, "method": "test", "params": ["4"]}`))
+	require.NoError(t, err)
+	require.Len(t, reqs, 4)
+	require.NoError(t, updaterImp.Warmup(reqs))
+
+	for _, param := range []string{"1", "2", "4"} {
+		params := []interface{}{param}
+		cachedResp, err := cacher.GetResponseCache(requests.RPCRequest{Method: method, Params: params})
+		require.NoError(t, err)
+		require.Equal(t, params, cachedResp.Result)
+	}
+	cachedReqs, err := cache.Requests(cacher.Cacher())
+	require.NoError(t, err)
+	require.Len(t, cachedReqs, 3)
+
+	_, err = readWarmupRequests(strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "test"}
+{"jsonrpc": "2.0", "id": 1, "params": []}`))
+	require.Error(t, err)
+	_, err = readWarmupRequests(strings.NewReader(`{"jsonrpc": "2.0", "id": 1,`))
+	require.Error(t, err)
+}
+
+type testHead int64
+
+func (h testHead) Height() int64 {
+	return int64(h)
+}
+
+func (h testHead) Head() (chain.Head, bool) {
+	return chain.Head{Height: int64(h)}, true
+}
+
+func TestMethodRequestsGenerators(t *testing.T) {
+	conf, err := testhelpers.GetConfigWithCustomMethods("http://test.com", method)
+	require.NoError(t, err)
+	conf.CacheMethods[0].ParamsForRequest = []interface{}{"${height}", nil}
+	conf.CacheMethods[0].ParamsGenerators = map[string]config.ParamsGenerator{
+		"height": {Kind: config.HeadHeightGenerator, Count: 3},
+	}
+	require.NoError(t, conf.Validate())
+	cacher := proxy.NewResponseCache(
+		cache.NewMemoryCacheFromConfig(conf.CacheSettings.Memory),
+		matcher.FromConfig(conf),
+	)
+	updaterImp, err := FromConfig(conf, cacher, logger.Log)
+	require.NoError(t, err)
+	require.Empty(t, updaterImp.methodRequests())
+
+	updaterImp.SetHeadProvider(testHead(10))
+	reqs := updaterImp.methodRequests()
+	require.Len(t, reqs, 3)
+	for idx, req := range reqs {
+		require.Equal(t, float64(idx+1), req.ID)
+		require.Equal(t, []interface{}{float64(10 - idx), nil}, req.Params)
+	}
+}
@@ -0,0 +1,70 @@
+package updater
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+)
+
+// ReadWarmupFile reads JSON-RPC requests of the JSONL file, one request per line
+func ReadWarmupFile(path string) (requests.RPCRequests, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open warmup file: %w", err)
+	}
+	defer f.Close()
+	reqs, err := readWarmupRequests(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read warmup file %s: %w", path, err)
+	}
+	return reqs, nil
+}
+
+func readWarmupRequests(r io.Reader) (requests.RPCRequests, error) {
+	reqs := requests.RPCRequests{}
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			req := requests.RPCRequest{}
+			if err := json.Unmarshal(data, &req); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			if req.Method == "" {
+				return nil, fmt.Errorf("line %d: method is required", line)
+			}
+			reqs = append(reqs, req)
+		}
+		if err == io.EOF {
+			return reqs, nil
+		}
+	}
+}
+
+// Warmup replays the requests through the upstream and caches their responses.
+// Requests of methods which are not cached are skipped
+func (u *Updater) Warmup(reqs requests.RPCRequests) error {
+	warmup := requests.RPCRequests{}
+	counter := float64(1)
+	for _, req := range reqs {
+		if len(u.cacher.Matcher().Keys(req.Method, req.Params)) == 0 {
+			u.logger.Warnf("Skipping warmup request of not cached method %s", req.Method)
+			continue
+		}
+		// identifiers of the file are not unique
+		req.JSONRPC = "2.0"
+		req.ID = counter
+		warmup = append(warmup, req)
+		counter++
+	}
+	u.logger.Infof("Warming up cache with %d requests...", len(warmup))
+	return u.update(warmup)
+}